@@ -0,0 +1,293 @@
+package v4
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+aws-chunked payload signing
+https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-streaming.html
+
+every chunk is encoded as
+	hex(chunk-size);chunk-signature=signature\r\n
+	chunk-data\r\n
+and the body ends with a chunk of size 0.
+*/
+
+// DefaultChunkSize is used by WithChunkedPayload when size <= 0
+const DefaultChunkSize = 64 * 1024
+
+const (
+	chunkSignaturePrefix = ";chunk-signature="
+	awsChunkedEncoding   = "aws-chunked"
+)
+
+var (
+	crlf            = []byte{'\r', '\n'}
+	emptySHA256Hex  = hex.EncodeToString(gsha256(nil))
+	signatureHexLen = 64
+)
+
+// chunkSigner chains chunk signatures from the seed signature
+type chunkSigner struct {
+	key     []byte
+	date    string
+	scope   string
+	prevSig string
+}
+
+func newChunkSigner(key []byte, t time.Time, region, name, seed string) *chunkSigner {
+	return &chunkSigner{
+		key:     key,
+		date:    t.Format(iSO8601BasicFormat),
+		scope:   creds(t, region, name),
+		prevSig: seed,
+	}
+}
+
+// sign returns the signature of data and remembers it for the next chunk
+func (s *chunkSigner) sign(data []byte) []byte {
+	stringToSign := strings.Join([]string{
+		aws4HmacSha256PayloadAlgorithm,
+		s.date,
+		s.scope,
+		s.prevSig,
+		emptySHA256Hex,
+		hex.EncodeToString(gsha256(data)),
+	}, "\n")
+	signature := ghmac(s.key, []byte(stringToSign))
+	s.prevSig = hex.EncodeToString(signature)
+	return signature
+}
+
+// chunkedReader encodes src as signed aws-chunked
+type chunkedReader struct {
+	src    io.ReadCloser
+	signer *chunkSigner
+	chunk  []byte
+	out    bytes.Buffer
+	done   bool
+}
+
+func newChunkedReader(src io.ReadCloser, signer *chunkSigner, chunkSize int) *chunkedReader {
+	return &chunkedReader{
+		src:    src,
+		signer: signer,
+		chunk:  make([]byte, chunkSize),
+	}
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.src, r.chunk)
+		switch {
+		case err == nil || errors.Is(err, io.ErrUnexpectedEOF):
+			r.writeChunk(r.chunk[:n])
+		case errors.Is(err, io.EOF):
+			r.writeChunk(nil)
+			r.done = true
+		default:
+			return 0, err
+		}
+	}
+	return r.out.Read(p)
+}
+
+func (r *chunkedReader) writeChunk(data []byte) {
+	r.out.WriteString(strconv.FormatInt(int64(len(data)), 16))
+	r.out.WriteString(chunkSignaturePrefix)
+	r.out.WriteString(hex.EncodeToString(r.signer.sign(data)))
+	r.out.Write(crlf)
+	r.out.Write(data)
+	r.out.Write(crlf)
+}
+
+func (r *chunkedReader) Close() error {
+	return r.src.Close()
+}
+
+// chunkedContentLength is the encoded length of decoded bytes split by chunkSize
+func chunkedContentLength(decoded int64, chunkSize int) int64 {
+	chunkLen := func(size int64) int64 {
+		return int64(len(strconv.FormatInt(size, 16))+len(chunkSignaturePrefix)+signatureHexLen+2*len(crlf)) + size
+	}
+	size := int64(chunkSize)
+	full := decoded / size
+	length := full * chunkLen(size)
+	if rest := decoded % size; rest > 0 {
+		length += chunkLen(rest)
+	}
+	return length + chunkLen(0)
+}
+
+// prepareChunked sets the streaming headers before the seed signature is computed
+func prepareChunked(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return fmt.Errorf("chunked payload needs a body")
+	}
+	if req.ContentLength < 0 {
+		return fmt.Errorf("chunked payload needs a known ContentLength")
+	}
+	encoding := awsChunkedEncoding
+	if old := req.Header.Get(headKeyContentEncoding); old != "" {
+		encoding += "," + old
+	}
+	req.Header.Set(headKeyContentEncoding, encoding)
+	req.Header.Set(headKeyDecodedContentLength, strconv.FormatInt(req.ContentLength, 10))
+	req.Header.Set(headKeyContentSHA256, StreamingPayload)
+	return nil
+}
+
+// wrapChunked replaces the body with the signed aws-chunked encoding of it
+func wrapChunked(req *http.Request, signer *chunkSigner, chunkSize int) {
+	seed := signer.prevSig
+	decoded := req.ContentLength
+	req.Body = newChunkedReader(req.Body, signer, chunkSize)
+	req.ContentLength = chunkedContentLength(decoded, chunkSize)
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			s := *signer
+			s.prevSig = seed
+			return newChunkedReader(body, &s, chunkSize), nil
+		}
+	}
+}
+
+// maxChunkSize limits the memory used by one chunk on the server
+const maxChunkSize = 16 * 1024 * 1024
+
+// maxChunkHeaderSize is the longest chunk header line: a 64 bit hex size, the signature and CRLF
+var maxChunkHeaderSize = 16 + len(chunkSignaturePrefix) + 2*sha256.Size + len(crlf)
+
+// chunkedDecoder strips aws-chunked framing and verifies every chunk before returning its data
+type chunkedDecoder struct {
+	src     *bufio.Reader
+	closer  io.Closer
+	signer  *chunkSigner
+	data    []byte
+	decoded int64
+	expect  int64
+	err     error
+}
+
+func newChunkedDecoder(src io.ReadCloser, signer *chunkSigner, decodedLength int64) *chunkedDecoder {
+	return &chunkedDecoder{
+		src:    bufio.NewReader(src),
+		closer: src,
+		signer: signer,
+		expect: decodedLength,
+	}
+}
+
+func (d *chunkedDecoder) Read(p []byte) (int, error) {
+	for len(d.data) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.nextChunk()
+	}
+	n := copy(p, d.data)
+	d.data = d.data[n:]
+	return n, nil
+}
+
+// nextChunk reads and verifies one chunk, io.EOF after the final chunk
+func (d *chunkedDecoder) nextChunk() error {
+	// ReadSlice stops when the buffer is full, a line without end is never buffered whole
+	raw, err := d.src.ReadSlice('\n')
+	if len(raw) > maxChunkHeaderSize || errors.Is(err, bufio.ErrBufferFull) {
+		return fmt.Errorf("%w: chunk header is longer than %d bytes", ErrInvalidPayload, maxChunkHeaderSize)
+	}
+	if err != nil {
+		return fmt.Errorf("read chunk header: %w", unexpectedEOF(err))
+	}
+	line := strings.TrimSuffix(string(raw), "\r\n")
+	sizeStr, signature, ok := strings.Cut(line, chunkSignaturePrefix)
+	if !ok {
+		return fmt.Errorf("%w: invalid chunk header: %q", ErrInvalidPayload, line)
+	}
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return fmt.Errorf("%w: invalid chunk size: %q", ErrInvalidPayload, sizeStr)
+	}
+
+	data := make([]byte, size+int64(len(crlf)))
+	if _, err = io.ReadFull(d.src, data); err != nil {
+		return fmt.Errorf("read chunk data: %w", unexpectedEOF(err))
+	}
+	if !bytes.HasSuffix(data, crlf) {
+		return fmt.Errorf("%w: chunk data is not terminated by CRLF", ErrInvalidPayload)
+	}
+	data = data[:size]
+
+	if !signatureEqual(d.signer.sign(data), signature) {
+		return fmt.Errorf("%w: chunk at decoded offset %d", ErrSignatureMismatch, d.decoded)
+	}
+	d.decoded += size
+	if size > 0 {
+		if d.decoded > d.expect {
+			return fmt.Errorf("%w: chunked body is longer than %s: %d", ErrInvalidPayload, headKeyDecodedContentLength, d.expect)
+		}
+		d.data = data
+		return nil
+	}
+	if d.decoded != d.expect {
+		return fmt.Errorf("%w: chunked body length %d does not match %s: %d", ErrInvalidPayload, d.decoded, headKeyDecodedContentLength, d.expect)
+	}
+	return io.EOF
+}
+
+func (d *chunkedDecoder) Close() error {
+	return d.closer.Close()
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// unwrapChunked replaces the body with the verifying decoder of it
+func unwrapChunked(req *http.Request, signer *chunkSigner) error {
+	decoded, err := strconv.ParseInt(req.Header.Get(headKeyDecodedContentLength), 10, 64)
+	if err != nil || decoded < 0 {
+		return fmt.Errorf("%w: invalid %s: %q", ErrInvalidPayload, headKeyDecodedContentLength, req.Header.Get(headKeyDecodedContentLength))
+	}
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	req.Body = newChunkedDecoder(req.Body, signer, decoded)
+	req.ContentLength = decoded
+
+	encodings := make([]string, 0, 1)
+	for _, item := range strings.Split(req.Header.Get(headKeyContentEncoding), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && item != awsChunkedEncoding {
+			encodings = append(encodings, item)
+		}
+	}
+	if len(encodings) == 0 {
+		req.Header.Del(headKeyContentEncoding)
+	} else {
+		req.Header.Set(headKeyContentEncoding, strings.Join(encodings, ","))
+	}
+	return nil
+}
@@ -2,15 +2,42 @@ package v4
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // SignRequestWithAwsV4 signs an HTTP request with the given AWS keys for use on service
-// use authorization header
-func SignRequestWithAwsV4(req *http.Request, key *Key, region, name string) (sp *SignProcess, err error) {
+// use authorization header, a *Key is a CredentialsProvider of itself
+func SignRequestWithAwsV4(req *http.Request, provider CredentialsProvider, region, name string, opts ...SignOption) (sp *SignProcess, err error) {
+	var key *Key
+	if key, err = provider.Retrieve(req.Context()); err != nil {
+		return
+	}
+	o := newSignOptions(opts)
+	c := o.canonical(region)
+	switch {
+	case o.chunkSize > 0 && c.isV4a():
+		err = fmt.Errorf("chunked payload is not supported by %s", aws4EcdsaP256Sha256Algorithm)
+		return
+	case o.chunkSize > 0:
+		if err = prepareChunked(req); err != nil {
+			return
+		}
+		c.payloadHash = StreamingPayload
+	case o.payloadHash != "":
+		if err = setPayloadHash(req, o.payloadHash); err != nil {
+			return
+		}
+		c.payloadHash = o.payloadHash
+	}
+
 	date := req.Header.Get(headKeyData)
 	t := time.Now().UTC()
 	if date != "" {
@@ -20,26 +47,47 @@ func SignRequestWithAwsV4(req *http.Request, key *Key, region, name string) (sp
 		}
 	}
 	req.Header.Set(headKeyXAmzDate, t.Format(iSO8601BasicFormat))
+	if key.SessionToken != "" {
+		req.Header.Set(headKeySecurityToken, key.SessionToken)
+	}
+	if c.isV4a() {
+		req.Header.Set(headKeyRegionSet, strings.Join(o.regionSet, ","))
+	}
 
-	sp = new(SignProcess)
-	sp.Key = key.Sign(t, region, name)
-	writeStringToSign(t, req, nil, sp, false, region, name)
+	if sp, err = signCanonical(t, req, key, c, region, name); err != nil {
+		return
+	}
+	seed := hex.EncodeToString(sp.AllSHA256)
 
-	auth := bytes.NewBufferString(aws4HmacSha256Algorithm + " ")
-	auth.Write([]byte("Credential=" + key.AccessKey + "/" + creds(t, region, name)))
+	auth := bytes.NewBufferString(c.algorithmName() + " ")
+	auth.Write([]byte("Credential=" + key.AccessKey + "/" + c.scope(t, region, name)))
 	auth.Write([]byte{',', ' '})
 	auth.Write([]byte("SignedHeaders="))
-	writeHeaderList(req, nil, auth, false)
+	writeHeaderList(req, nil, c, auth, false)
 	auth.Write([]byte{',', ' '})
-	auth.Write([]byte("Signature=" + hex.EncodeToString(sp.AllSHA256)))
+	auth.Write([]byte("Signature=" + seed))
 
 	req.Header.Set(headKeyAuthorization, auth.String())
+
+	if o.chunkSize > 0 {
+		wrapChunked(req, newChunkSigner(sp.Key, t, region, name, seed), o.chunkSize)
+	}
 	return
 }
 
 // SignRequestWithAwsV4UseQueryString signs an HTTP request with the given AWS keys for use on service
-// use query string
-func SignRequestWithAwsV4UseQueryString(req *http.Request, key *Key, region, name string) (sp *SignProcess, err error) {
+// use query string, the url expires after DefaultPresignExpires unless WithExpires is given.
+// The payload is UNSIGNED-PAYLOAD, as S3 does, unless WithPayloadHash is given.
+func SignRequestWithAwsV4UseQueryString(req *http.Request, provider CredentialsProvider, region, name string, opts ...SignOption) (sp *SignProcess, err error) {
+	var key *Key
+	if key, err = provider.Retrieve(req.Context()); err != nil {
+		return
+	}
+	o := newSignOptions(opts)
+	if o.expires < time.Second || o.expires > MaxPresignExpires {
+		err = fmt.Errorf("invalid expires: %s, must be in [1s, %s]", o.expires, MaxPresignExpires)
+		return
+	}
 	date := req.Header.Get(headKeyData)
 	t := time.Now().UTC()
 	if date != "" {
@@ -51,19 +99,33 @@ func SignRequestWithAwsV4UseQueryString(req *http.Request, key *Key, region, nam
 	values := req.URL.Query()
 	values.Set(queryKeyDate, t.Format(iSO8601BasicFormat))
 
-	req.Header.Set(headKeyHost, req.Host)
-
-	sp = new(SignProcess)
-	sp.Key = key.Sign(t, region, name)
+	req.Header.Set(headKeyHost, requestHost(req))
+	c := o.canonical(region)
+	c.payloadHash = UnsignedPayload
+	if o.payloadHash != "" {
+		if err = setPayloadHash(req, o.payloadHash); err != nil {
+			return
+		}
+		c.payloadHash = o.payloadHash
+	}
 
-	values.Set(queryKeyAlgorithm, aws4HmacSha256Algorithm)
-	values.Set(queryKeyCredential, key.AccessKey+"/"+creds(t, region, name))
+	values.Set(queryKeyAlgorithm, c.algorithmName())
+	values.Set(queryKeyCredential, key.AccessKey+"/"+c.scope(t, region, name))
+	if c.isV4a() {
+		values.Set(queryKeyRegionSet, strings.Join(o.regionSet, ","))
+	}
+	if key.SessionToken != "" {
+		values.Set(queryKeySecurityToken, key.SessionToken)
+	}
+	values.Set(queryKeyExpires, strconv.FormatInt(int64(o.expires/time.Second), 10))
 	cc := bytes.NewBufferString("")
-	writeHeaderList(req, nil, cc, false)
+	writeHeaderList(req, nil, c, cc, false)
 	values.Set(queryKeySignatureHeaders, cc.String())
 	req.URL.RawQuery = values.Encode()
 
-	writeStringToSign(t, req, nil, sp, false, region, name)
+	if sp, err = signCanonical(t, req, key, c, region, name); err != nil {
+		return
+	}
 	values = req.URL.Query()
 	values.Set(queryKeySignature, hex.EncodeToString(sp.AllSHA256))
 	req.URL.RawQuery = values.Encode()
@@ -71,11 +133,54 @@ func SignRequestWithAwsV4UseQueryString(req *http.Request, key *Key, region, nam
 	return
 }
 
-// KeysFromEnvironment Initializes and returns a Keys using the AWS_ACCESS_KEY and AWS_SECRET_KEY
-// environment variables.
+// signCanonical signs the request with HMAC-SHA256 or, for Signature Version 4A, ECDSA
+func signCanonical(t time.Time, req *http.Request, key *Key, c *canonical, region, name string) (sp *SignProcess, err error) {
+	sp = new(SignProcess)
+	if !c.isV4a() {
+		if key.SecretKey == "" {
+			return nil, fmt.Errorf("can not sign %s without secret key of %s", aws4HmacSha256Algorithm, key.AccessKey)
+		}
+		sp.Key = defaultSigningKeyCache.Sign(key, t, region, name)
+		if err = writeStringToSign(t, req, nil, sp, c, false, region, name); err != nil {
+			return nil, err
+		}
+		return
+	}
+
+	var priv *ecdsa.PrivateKey
+	if priv, err = defaultSigningKeyCache.ECDSAKey(key); err != nil {
+		return
+	}
+	if err = writeStringToSign(t, req, nil, sp, c, false, region, name); err != nil {
+		return nil, err
+	}
+	sp.AllSHA256, err = ecdsa.SignASN1(rand.Reader, priv, gsha256(sp.All))
+	return
+}
+
+func setPayloadHash(req *http.Request, hash string) error {
+	if hash != UnsignedPayload && !isSHA256Hex(hash) {
+		return fmt.Errorf("invalid payload hash: %s", hash)
+	}
+	req.Header.Set(headKeyContentSHA256, hash)
+	return nil
+}
+
+// KeysFromEnvironment Initializes and returns a Keys using the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN environment variables, falling back to AWS_ACCESS_KEY and AWS_SECRET_KEY.
 func KeysFromEnvironment() *Key {
 	return &Key{
-		AccessKey: os.Getenv("AWS_ACCESS_KEY"),
-		SecretKey: os.Getenv("AWS_SECRET_KEY"),
+		AccessKey:    getenv("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY"),
+		SecretKey:    getenv("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+func getenv(keys ...string) string {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
 	}
+	return ""
 }
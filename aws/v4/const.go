@@ -1,11 +1,19 @@
 package v4
 
+import "time"
+
 // head key, case insensitive
 const (
 	headKeyData          = "date"
 	headKeyXAmzDate      = "x-amz-date"
 	headKeyAuthorization = "authorization"
 	headKeyHost          = "host"
+
+	headKeyContentSHA256        = "x-amz-content-sha256"
+	headKeyContentEncoding      = "content-encoding"
+	headKeyDecodedContentLength = "x-amz-decoded-content-length"
+	headKeyRegionSet            = "x-amz-region-set"
+	headKeySecurityToken        = "x-amz-security-token"
 )
 
 // url query params
@@ -15,8 +23,32 @@ const (
 	queryKeyCredential       = "X-Amz-Credential"
 	queryKeyDate             = "X-Amz-Date"
 	queryKeySignatureHeaders = "X-Amz-SignedHeaders"
+	queryKeyExpires          = "X-Amz-Expires"
+	queryKeyRegionSet        = "X-Amz-Region-Set"
+	queryKeySecurityToken    = "X-Amz-Security-Token"
+)
+
+const (
+	aws4HmacSha256Algorithm        = "AWS4-HMAC-SHA256"
+	aws4HmacSha256PayloadAlgorithm = "AWS4-HMAC-SHA256-PAYLOAD"
+	aws4EcdsaP256Sha256Algorithm   = "AWS4-ECDSA-P256-SHA256"
 )
 
+// x-amz-content-sha256 values which are not a digest of the body
 const (
-	aws4HmacSha256Algorithm = "AWS4-HMAC-SHA256"
+	// StreamingPayload means the body is aws-chunked and every chunk is signed
+	StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// UnsignedPayload means the body is not covered by the signature
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
 )
+
+// presigned url expiry
+const (
+	// MaxPresignExpires is the longest X-Amz-Expires allowed by Signature Version 4
+	MaxPresignExpires = 7 * 24 * time.Hour
+	// DefaultPresignExpires is used by SignRequestWithAwsV4UseQueryString when no expiry is given
+	DefaultPresignExpires = 15 * time.Minute
+)
+
+// DefaultMaxSkew is the clock skew allowed between client and server for header signed requests, same as AWS
+const DefaultMaxSkew = 15 * time.Minute
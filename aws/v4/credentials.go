@@ -0,0 +1,297 @@
+package v4
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialsProvider retrieves the Key used to sign requests
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (*Key, error)
+}
+
+// Retrieve makes a Key its own static provider
+func (k *Key) Retrieve(context.Context) (*Key, error) {
+	if k == nil || k.AccessKey == "" || k.SecretKey == "" {
+		return nil, errors.New("empty access key or secret key")
+	}
+	return k, nil
+}
+
+// Expired reports whether temporary credentials have expired at t
+func (k *Key) Expired(t time.Time) bool {
+	return !k.Expires.IsZero() && !t.Before(k.Expires)
+}
+
+// StaticProvider always returns the same Key
+type StaticProvider struct {
+	Key Key
+}
+
+// NewStaticProvider returns a StaticProvider, sessionToken may be empty
+func NewStaticProvider(accessKey, secretKey, sessionToken string) *StaticProvider {
+	return &StaticProvider{Key: Key{
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		SessionToken: sessionToken,
+	}}
+}
+
+// Retrieve implements CredentialsProvider
+func (p *StaticProvider) Retrieve(ctx context.Context) (*Key, error) {
+	key := p.Key
+	return key.Retrieve(ctx)
+}
+
+// EnvProvider reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN,
+// AWS_ACCESS_KEY and AWS_SECRET_KEY are still accepted
+type EnvProvider struct{}
+
+// Retrieve implements CredentialsProvider
+func (EnvProvider) Retrieve(context.Context) (*Key, error) {
+	key := KeysFromEnvironment()
+	if key.AccessKey == "" || key.SecretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY not found in environment")
+	}
+	return key, nil
+}
+
+// SharedCredentialsProvider reads a profile of the shared credentials file
+type SharedCredentialsProvider struct {
+	// Filename defaults to AWS_SHARED_CREDENTIALS_FILE, then ~/.aws/credentials
+	Filename string
+	// Profile defaults to AWS_PROFILE, then "default"
+	Profile string
+}
+
+// Retrieve implements CredentialsProvider, a profile with only credential_process runs the command
+func (p *SharedCredentialsProvider) Retrieve(ctx context.Context) (*Key, error) {
+	filename, err := p.filename()
+	if err != nil {
+		return nil, err
+	}
+	profile := p.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	sections, err := loadINI(filename)
+	if err != nil {
+		return nil, err
+	}
+	section, ok := sections[profile]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found in %s", profile, filename)
+	}
+	key := &Key{
+		AccessKey:    section["aws_access_key_id"],
+		SecretKey:    section["aws_secret_access_key"],
+		SessionToken: section["aws_session_token"],
+	}
+	if key.AccessKey == "" && key.SecretKey == "" && section["credential_process"] != "" {
+		return (&ProcessProvider{Command: section["credential_process"]}).Retrieve(ctx)
+	}
+	if key.AccessKey == "" || key.SecretKey == "" {
+		return nil, fmt.Errorf("profile %s in %s has no aws_access_key_id or aws_secret_access_key", profile, filename)
+	}
+	return key, nil
+}
+
+func (p *SharedCredentialsProvider) filename() (string, error) {
+	if p.Filename != "" {
+		return p.Filename, nil
+	}
+	if filename := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); filename != "" {
+		return filename, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws", "credentials"), nil
+}
+
+// loadINI parses sections of key = value lines, # and ; start a comment
+func loadINI(filename string) (map[string]map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections := make(map[string]map[string]string)
+	var section map[string]string
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: invalid section: %s", filename, lineNo, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if section = sections[name]; section == nil {
+				section = make(map[string]string)
+				sections[name] = section
+			}
+		default:
+			k, v, ok := strings.Cut(line, "=")
+			if !ok || section == nil {
+				return nil, fmt.Errorf("%s:%d: invalid line: %s", filename, lineNo, line)
+			}
+			section[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return sections, scanner.Err()
+}
+
+/*
+ProcessProvider runs a credential_process command
+https://docs.aws.amazon.com/cli/latest/userguide/cli-configure-sourcing-external.html
+*/
+type ProcessProvider struct {
+	Command string
+}
+
+// Retrieve implements CredentialsProvider
+func (p *ProcessProvider) Retrieve(ctx context.Context) (*Key, error) {
+	if p.Command == "" {
+		return nil, errors.New("empty credential_process")
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/C", p.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", p.Command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("credential_process: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("credential_process: %w", err)
+	}
+
+	var result struct {
+		Version         int
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		SessionToken    string
+		Expiration      *time.Time
+	}
+	if err = json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("credential_process: invalid output: %w", err)
+	}
+	if result.Version != 1 {
+		return nil, fmt.Errorf("credential_process: unsupported version: %d", result.Version)
+	}
+	if result.AccessKeyID == "" || result.SecretAccessKey == "" {
+		return nil, errors.New("credential_process: empty AccessKeyId or SecretAccessKey")
+	}
+	key := &Key{
+		AccessKey:    result.AccessKeyID,
+		SecretKey:    result.SecretAccessKey,
+		SessionToken: result.SessionToken,
+	}
+	if result.Expiration != nil {
+		key.Expires = *result.Expiration
+	}
+	return key, nil
+}
+
+// ChainProvider returns the Key of the first provider which succeeds
+type ChainProvider struct {
+	Providers []CredentialsProvider
+}
+
+// NewChainProvider tries providers in order
+func NewChainProvider(providers ...CredentialsProvider) *ChainProvider {
+	return &ChainProvider{Providers: providers}
+}
+
+// Retrieve implements CredentialsProvider
+func (p *ChainProvider) Retrieve(ctx context.Context) (*Key, error) {
+	errs := make([]error, 0, len(p.Providers))
+	for _, provider := range p.Providers {
+		key, err := provider.Retrieve(ctx)
+		if err == nil {
+			return key, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("no valid credentials in chain: %w", errors.Join(errs...))
+}
+
+// DefaultExpiryWindow is how long before Expires the CachedProvider refreshes
+const DefaultExpiryWindow = 5 * time.Minute
+
+// CachedProvider keeps the Key of Provider until ExpiryWindow before it expires.
+// It is safe for concurrent use.
+type CachedProvider struct {
+	Provider     CredentialsProvider
+	ExpiryWindow time.Duration
+
+	mu  sync.Mutex
+	key *Key
+	now func() time.Time
+}
+
+// NewCachedProvider caches provider, refreshing DefaultExpiryWindow before expiry
+func NewCachedProvider(provider CredentialsProvider) *CachedProvider {
+	return &CachedProvider{
+		Provider:     provider,
+		ExpiryWindow: DefaultExpiryWindow,
+	}
+}
+
+// Retrieve implements CredentialsProvider
+func (p *CachedProvider) Retrieve(ctx context.Context) (*Key, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.now != nil {
+		now = p.now()
+	}
+	if p.key != nil && !p.key.Expired(now.Add(p.ExpiryWindow)) {
+		return p.key, nil
+	}
+	key, err := p.Provider.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.key = key
+	return key, nil
+}
+
+// Invalidate drops the cached Key
+func (p *CachedProvider) Invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = nil
+}
+
+// DefaultCredentialsChain reads the environment, then the shared credentials file, and caches the result
+func DefaultCredentialsChain() *CachedProvider {
+	return NewCachedProvider(NewChainProvider(
+		EnvProvider{},
+		&SharedCredentialsProvider{},
+	))
+}
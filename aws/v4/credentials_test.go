@@ -0,0 +1,152 @@
+package v4
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedCredentialsProvider(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "credentials")
+	content := `# comment
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = secret/default
+
+[temp]
+aws_access_key_id=AKIDTEMP
+aws_secret_access_key=secret/temp
+aws_session_token=token
+`
+	if runtime.GOOS != "windows" {
+		content += `
+[process]
+credential_process = echo '{"Version": 1, "AccessKeyId": "AKIDPROCESS", "SecretAccessKey": "secret/process", "Expiration": "2030-01-01T00:00:00Z"}'
+`
+	}
+	assert.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+	ctx := context.Background()
+
+	key, err := (&SharedCredentialsProvider{Filename: filename}).Retrieve(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIDDEFAULT", key.AccessKey)
+
+	key, err = (&SharedCredentialsProvider{Filename: filename, Profile: "temp"}).Retrieve(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "token", key.SessionToken)
+
+	_, err = (&SharedCredentialsProvider{Filename: filename, Profile: "none"}).Retrieve(ctx)
+	assert.Error(t, err)
+
+	if runtime.GOOS != "windows" {
+		key, err = (&SharedCredentialsProvider{Filename: filename, Profile: "process"}).Retrieve(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "AKIDPROCESS", key.AccessKey)
+		assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), key.Expires.UTC())
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_ACCESS_KEY", "")
+	t.Setenv("AWS_SECRET_KEY", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	ctx := context.Background()
+
+	_, err := EnvProvider{}.Retrieve(ctx)
+	assert.Error(t, err)
+
+	t.Setenv("AWS_ACCESS_KEY", "AKIDOLD")
+	t.Setenv("AWS_SECRET_KEY", "secret/old")
+	key, err := EnvProvider{}.Retrieve(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIDOLD", key.AccessKey)
+	assert.Equal(t, "secret/old", key.SecretKey)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret/env")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+	key, err = EnvProvider{}.Retrieve(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &Key{AccessKey: "AKIDENV", SecretKey: "secret/env", SessionToken: "token"}, key)
+}
+
+func TestProcessProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are written for sh")
+	}
+	ctx := context.Background()
+
+	key, err := (&ProcessProvider{Command: `echo '{"Version": 1, "AccessKeyId": "AKIDPROCESS", "SecretAccessKey": "secret/process", "SessionToken": "token"}'`}).Retrieve(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &Key{AccessKey: "AKIDPROCESS", SecretKey: "secret/process", SessionToken: "token"}, key)
+
+	// stderr is kept in the error instead of reaching the stderr of this process
+	_, err = (&ProcessProvider{Command: "echo 'sso session expired' >&2; exit 1"}).Retrieve(ctx)
+	assert.ErrorContains(t, err, "sso session expired")
+
+	_, err = (&ProcessProvider{Command: `echo '{"Version": 2, "AccessKeyId": "AKID", "SecretAccessKey": "secret"}'`}).Retrieve(ctx)
+	assert.ErrorContains(t, err, "unsupported version")
+
+	_, err = (&ProcessProvider{Command: "echo not json"}).Retrieve(ctx)
+	assert.ErrorContains(t, err, "invalid output")
+
+	_, err = (&ProcessProvider{}).Retrieve(ctx)
+	assert.Error(t, err)
+}
+
+func TestChainProvider(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_ACCESS_KEY", "")
+	chain := NewChainProvider(EnvProvider{}, NewStaticProvider("AKIDSTATIC", "secret", ""))
+	key, err := chain.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIDSTATIC", key.AccessKey)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+	key, err = chain.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIDENV", key.AccessKey)
+	assert.Equal(t, "token", key.SessionToken)
+}
+
+type countProvider struct {
+	count   int
+	expires time.Time
+}
+
+func (p *countProvider) Retrieve(context.Context) (*Key, error) {
+	p.count++
+	if p.count > 2 {
+		return nil, errors.New("too many calls")
+	}
+	return &Key{AccessKey: "AKID", SecretKey: "secret", Expires: p.expires}, nil
+}
+
+func TestCachedProvider(t *testing.T) {
+	now := time.Date(2023, 10, 23, 0, 0, 0, 0, time.UTC)
+	base := &countProvider{expires: now.Add(time.Hour)}
+	p := NewCachedProvider(base)
+	p.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := p.Retrieve(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, base.count)
+
+	// refresh inside the expiry window
+	now = now.Add(time.Hour - DefaultExpiryWindow)
+	_, err := p.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, base.count)
+}
@@ -0,0 +1,94 @@
+package v4
+
+import (
+	"errors"
+)
+
+// errors returned by the Check* functions, test them with errors.Is
+var (
+	// ErrMalformedAuthorization means the authorization header or query, or one of its parts, can not be parsed
+	ErrMalformedAuthorization = errors.New("malformed authorization")
+	// ErrUnsupportedAlgorithm means the algorithm is neither AWS4-HMAC-SHA256 nor AWS4-ECDSA-P256-SHA256
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	// ErrUnknownAccessKey means the access key is not found, disabled or expired
+	ErrUnknownAccessKey = errors.New("unknown access key")
+	// ErrScopeMismatch means the credential scope does not match the region or name of the server
+	ErrScopeMismatch = errors.New("credential scope mismatch")
+	// ErrInvalidDate means the request date is missing, invalid or does not match the credential scope
+	ErrInvalidDate = errors.New("missing or invalid date")
+	// ErrRequestTimeSkewed means the request date is too far from the server time, see SkewError
+	ErrRequestTimeSkewed = errors.New("request time too skewed")
+	// ErrExpired means a query string request is past X-Amz-Expires
+	ErrExpired = errors.New("request has expired")
+	// ErrSignatureMismatch means the signature, or the signature of a chunk, does not match
+	ErrSignatureMismatch = errors.New("signature does not match")
+	// ErrInvalidSecurityToken means X-Amz-Security-Token is missing, expired or mismatched
+	ErrInvalidSecurityToken = errors.New("invalid security token")
+	// ErrInvalidPayload means x-amz-content-sha256 is invalid, not allowed or does not match the body
+	ErrInvalidPayload = errors.New("invalid payload")
+)
+
+// DisclosureMode decides how much of the signing process a signature mismatch error tells
+type DisclosureMode int
+
+const (
+	// DisclosureNone only tells the signature does not match, the default
+	DisclosureNone DisclosureMode = iota
+	// DisclosureCanonical adds the canonical request and the string to sign, as AWS does
+	DisclosureCanonical
+	// DisclosureDebug adds the whole SignProcess, including the body, the derived key and the expected signature.
+	// Never use it in production.
+	DisclosureDebug
+)
+
+// SignatureMismatchError is ErrSignatureMismatch with what the DisclosureMode allows
+type SignatureMismatchError struct {
+	Mode DisclosureMode
+	// CanonicalRequest and StringToSign are empty for DisclosureNone
+	CanonicalRequest string
+	StringToSign     string
+	// Process is nil unless DisclosureDebug
+	Process *SignProcess
+
+	unknownKey bool
+}
+
+func (e *SignatureMismatchError) Error() string {
+	switch e.Mode {
+	case DisclosureCanonical:
+		return ErrSignatureMismatch.Error() +
+			"\ncanonical request:\n" + e.CanonicalRequest +
+			"\nstring to sign:\n" + e.StringToSign
+	case DisclosureDebug:
+		return ErrSignatureMismatch.Error() + "\n" + e.Process.String()
+	default:
+		return ErrSignatureMismatch.Error()
+	}
+}
+
+// Unwrap makes errors.Is(err, ErrSignatureMismatch) true,
+// and errors.Is(err, ErrUnknownAccessKey) too when the access key is unknown, disabled or expired.
+// Error is the same for both so clients can not enumerate access keys.
+func (e *SignatureMismatchError) Unwrap() []error {
+	if e.unknownKey {
+		return []error{ErrSignatureMismatch, ErrUnknownAccessKey}
+	}
+	return []error{ErrSignatureMismatch}
+}
+
+// signatureMismatch keeps the SignProcess, which holds the derived key and the expected signature,
+// only for DisclosureDebug
+func signatureMismatch(sp *SignProcess, o *checkOptions) (*SignProcess, error) {
+	e := &SignatureMismatchError{Mode: o.disclosure}
+	switch o.disclosure {
+	case DisclosureCanonical:
+		e.CanonicalRequest = string(sp.Request)
+		e.StringToSign = string(sp.All)
+	case DisclosureDebug:
+		e.CanonicalRequest = string(sp.Request)
+		e.StringToSign = string(sp.All)
+		e.Process = sp
+		return sp, e
+	}
+	return nil, e
+}
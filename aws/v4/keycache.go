@@ -0,0 +1,147 @@
+package v4
+
+import (
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"sync"
+	"time"
+)
+
+// DefaultSigningKeyCacheSize is the size of the cache used when none is given
+const DefaultSigningKeyCacheSize = 1024
+
+var defaultSigningKeyCache = NewSigningKeyCache(DefaultSigningKeyCacheSize)
+
+/*
+SigningKeyCache keeps the keys derived by Key.Sign, which only change once a day per region and name,
+and the keys derived by Key.ECDSAKey, which never change.
+It is safe for concurrent use, the least recently used key is dropped when full,
+and keys older than the day before the latest one are dropped when the day rolls over.
+Only a sha256 digest of the secret key is kept, to tell a rotated secret key.
+A cache of size <= 0 derives every time.
+*/
+type SigningKeyCache struct {
+	size int
+
+	mu      sync.Mutex
+	latest  string
+	entries map[signingKeyID]*list.Element
+	order   *list.List
+}
+
+// signingKeyID of an ecdsa key has only the access key
+type signingKeyID struct {
+	accessKey, date, region, name string
+}
+
+type signingKeyEntry struct {
+	id     signingKeyID
+	secret [sha256.Size]byte
+	key    []byte
+	ecdsa  *ecdsa.PrivateKey
+}
+
+// NewSigningKeyCache holds at most size keys
+func NewSigningKeyCache(size int) *SigningKeyCache {
+	return &SigningKeyCache{
+		size:    size,
+		entries: make(map[signingKeyID]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Sign returns k.Sign(t, region, name), derived at most once while cached
+func (c *SigningKeyCache) Sign(k *Key, t time.Time, region, name string) []byte {
+	if c == nil || c.size <= 0 {
+		return k.Sign(t, region, name)
+	}
+	t = t.UTC()
+	id := signingKeyID{accessKey: k.AccessKey, date: t.Format(iSO8601BasicFormatShort), region: region, name: name}
+	secret := sha256.Sum256([]byte(k.SecretKey))
+	if entry := c.get(id, secret); entry != nil {
+		return entry.key
+	}
+
+	key := k.Sign(t, region, name)
+	c.put(&signingKeyEntry{id: id, secret: secret, key: key}, t)
+	return key
+}
+
+// ECDSAKey returns k.ECDSAKey(), derived at most once while cached.
+// The key is shared, it must not be modified.
+func (c *SigningKeyCache) ECDSAKey(k *Key) (*ecdsa.PrivateKey, error) {
+	if c == nil || c.size <= 0 || k.SecretKey == "" {
+		return k.ECDSAKey()
+	}
+	id := signingKeyID{accessKey: k.AccessKey}
+	secret := sha256.Sum256([]byte(k.SecretKey))
+	if entry := c.get(id, secret); entry != nil {
+		return entry.ecdsa, nil
+	}
+
+	priv, err := k.ECDSAKey()
+	if err != nil {
+		return nil, err
+	}
+	c.put(&signingKeyEntry{id: id, secret: secret, ecdsa: priv}, time.Time{})
+	return priv, nil
+}
+
+// get returns the entry of id, nil when missing or derived from another secret key
+func (c *SigningKeyCache) get(id signingKeyID, secret [sha256.Size]byte) *signingKeyEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*signingKeyEntry)
+	// the secret key may be rotated under the same access key
+	if subtle.ConstantTimeCompare(entry.secret[:], secret[:]) != 1 {
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return entry
+}
+
+// put adds entry derived at t, the zero time for an ecdsa key
+func (c *SigningKeyCache) put(entry *signingKeyEntry, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry.id.date > c.latest {
+		c.latest = entry.id.date
+		c.evictBefore(t.AddDate(0, 0, -1).Format(iSO8601BasicFormatShort))
+	}
+	if elem, ok := c.entries[entry.id]; ok {
+		c.order.Remove(elem)
+	}
+	c.entries[entry.id] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Len returns the number of cached keys
+func (c *SigningKeyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *SigningKeyCache) evictBefore(date string) {
+	for elem := c.order.Back(); elem != nil; {
+		prev := elem.Prev()
+		// ecdsa keys have no date
+		if id := elem.Value.(*signingKeyEntry).id; id.date != "" && id.date < date {
+			c.remove(elem)
+		}
+		elem = prev
+	}
+}
+
+func (c *SigningKeyCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*signingKeyEntry).id)
+}
@@ -0,0 +1,78 @@
+package v4
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningKeyCache(t *testing.T) {
+	c := NewSigningKeyCache(2)
+	key := &Key{AccessKey: "spiderman", SecretKey: "@C*u0NrTxs@Y89m#"}
+	day := time.Date(2023, 10, 23, 8, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, key.Sign(day, "r1", "n"), c.Sign(key, day, "r1", "n"))
+	assert.Equal(t, key.Sign(day, "r1", "n"), c.Sign(key, day.Add(time.Hour), "r1", "n"))
+	assert.Equal(t, 1, c.Len())
+
+	// rotated secret key
+	rotated := &Key{AccessKey: key.AccessKey, SecretKey: "new"}
+	assert.Equal(t, rotated.Sign(day, "r1", "n"), c.Sign(rotated, day, "r1", "n"))
+
+	c.Sign(key, day, "r2", "n")
+	c.Sign(key, day, "r3", "n")
+	assert.Equal(t, 2, c.Len())
+
+	// two days later, keys of the old day are dropped
+	c.Sign(key, day.AddDate(0, 0, 2), "r1", "n")
+	assert.Equal(t, 1, c.Len())
+
+	disabled := NewSigningKeyCache(0)
+	assert.Equal(t, key.Sign(day, "r1", "n"), disabled.Sign(key, day, "r1", "n"))
+	assert.Equal(t, 0, disabled.Len())
+}
+
+func TestSigningKeyCache_ECDSAKey(t *testing.T) {
+	c := NewSigningKeyCache(2)
+	key := &Key{AccessKey: "spiderman", SecretKey: "@C*u0NrTxs@Y89m#"}
+	day := time.Date(2023, 10, 23, 8, 0, 0, 0, time.UTC)
+
+	derived, err := key.ECDSAKey()
+	assert.NoError(t, err)
+	cached, err := c.ECDSAKey(key)
+	assert.NoError(t, err)
+	assert.True(t, derived.Equal(cached))
+	again, err := c.ECDSAKey(key)
+	assert.NoError(t, err)
+	assert.Same(t, cached, again)
+
+	// rotated secret key
+	rotated, err := c.ECDSAKey(&Key{AccessKey: key.AccessKey, SecretKey: "new"})
+	assert.NoError(t, err)
+	assert.False(t, derived.Equal(rotated))
+
+	// an ecdsa key outlives the day
+	c.Sign(key, day, "r1", "n")
+	c.Sign(key, day.AddDate(0, 0, 2), "r1", "n")
+	assert.Equal(t, 2, c.Len())
+
+	_, err = c.ECDSAKey(&Key{AccessKey: key.AccessKey})
+	assert.Error(t, err)
+}
+
+func BenchmarkSigningKeyCache(b *testing.B) {
+	key := &Key{AccessKey: "spiderman", SecretKey: "@C*u0NrTxs@Y89m#"}
+	now := time.Now()
+	b.Run("derive", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			key.Sign(now, "universal", "echo_server")
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		c := NewSigningKeyCache(DefaultSigningKeyCacheSize)
+		for i := 0; i < b.N; i++ {
+			c.Sign(key, now, "universal", "echo_server")
+		}
+	})
+}
@@ -0,0 +1,261 @@
+package v4
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrKeyNotFound is returned by a KeyStore for an unknown access key
+var ErrKeyNotFound = errors.New("access key not found")
+
+// KeyInfo is a Key with its metadata
+type KeyInfo struct {
+	Key
+	// Disabled keys are rejected as unknown
+	Disabled bool
+	// SigV4aOnly keys are rejected as unknown for AWS4-HMAC-SHA256,
+	// FileKeyStore sets it for keys with a public key and no secret key
+	SigV4aOnly bool
+	// Metadata is free-form, e.g. owner or tenant
+	Metadata map[string]string
+}
+
+// KeyStore looks up the Key of an access key id for verifiers.
+// It returns ErrKeyNotFound (or an error wrapping it) for an unknown access key.
+type KeyStore interface {
+	LookupKey(ctx context.Context, accessKey string) (*KeyInfo, error)
+}
+
+// KeyMap is a KeyStore of access key to secret key
+type KeyMap map[string]string
+
+// LookupKey implements KeyStore
+func (m KeyMap) LookupKey(_ context.Context, accessKey string) (*KeyInfo, error) {
+	secretKey, ok := m[accessKey]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &KeyInfo{Key: Key{AccessKey: accessKey, SecretKey: secretKey}}, nil
+}
+
+// MemoryKeyStore is a KeyStore safe for concurrent use
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*KeyInfo
+}
+
+// NewMemoryKeyStore holds infos
+func NewMemoryKeyStore(infos ...*KeyInfo) *MemoryKeyStore {
+	s := &MemoryKeyStore{keys: make(map[string]*KeyInfo, len(infos))}
+	for _, info := range infos {
+		s.keys[info.AccessKey] = info
+	}
+	return s
+}
+
+// LookupKey implements KeyStore
+func (s *MemoryKeyStore) LookupKey(_ context.Context, accessKey string) (*KeyInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info, ok := s.keys[accessKey]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return info, nil
+}
+
+// Add fails if the access key is already there
+func (s *MemoryKeyStore) Add(info *KeyInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string]*KeyInfo)
+	}
+	if _, ok := s.keys[info.AccessKey]; ok {
+		return fmt.Errorf("repeated key: %s", info.AccessKey)
+	}
+	s.keys[info.AccessKey] = info
+	return nil
+}
+
+// Remove deletes the access key
+func (s *MemoryKeyStore) Remove(accessKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, accessKey)
+}
+
+// replace swaps all keys at once
+func (s *MemoryKeyStore) replace(keys map[string]*KeyInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+/*
+FileKeyStore loads keys from a JSON (.json) or YAML (.yaml, .yml) file:
+
+	keys:
+	  - access_key: some_key_id
+	    secret_key: some_secret
+	    metadata:
+	      owner: some_team
+	  - access_key: some_v4a_key_id
+	    public_key: |
+	      -----BEGIN PUBLIC KEY-----
+	      ...
+
+Call Watch to reload the file whenever it changes.
+*/
+type FileKeyStore struct {
+	MemoryKeyStore
+
+	path     string
+	reloadMu sync.Mutex
+	modTime  time.Time
+	size     int64
+}
+
+type keyFile struct {
+	Keys []keyRecord `json:"keys" yaml:"keys"`
+}
+
+type keyRecord struct {
+	AccessKey    string            `json:"access_key" yaml:"access_key"`
+	SecretKey    string            `json:"secret_key,omitempty" yaml:"secret_key,omitempty"`
+	SessionToken string            `json:"session_token,omitempty" yaml:"session_token,omitempty"`
+	PublicKey    string            `json:"public_key,omitempty" yaml:"public_key,omitempty"`
+	Expires      time.Time         `json:"expires,omitempty" yaml:"expires,omitempty"`
+	Disabled     bool              `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// NewFileKeyStore loads path once
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the file again, the old keys are kept on error
+func (s *FileKeyStore) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	stat, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var file keyFile
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".json":
+		err = json.Unmarshal(data, &file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	default:
+		err = fmt.Errorf("unsupported key file: %s", s.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*KeyInfo, len(file.Keys))
+	for i, record := range file.Keys {
+		if record.AccessKey == "" {
+			return fmt.Errorf("%s: keys[%d] has no access_key", s.path, i)
+		}
+		if record.SecretKey == "" && record.PublicKey == "" {
+			return fmt.Errorf("%s: %s has neither secret_key nor public_key", s.path, record.AccessKey)
+		}
+		if _, ok := keys[record.AccessKey]; ok {
+			return fmt.Errorf("%s: repeated key: %s", s.path, record.AccessKey)
+		}
+		info := &KeyInfo{
+			Key: Key{
+				AccessKey:    record.AccessKey,
+				SecretKey:    record.SecretKey,
+				SessionToken: record.SessionToken,
+				Expires:      record.Expires,
+			},
+			Disabled:   record.Disabled,
+			SigV4aOnly: record.SecretKey == "",
+			Metadata:   record.Metadata,
+		}
+		if record.PublicKey != "" {
+			if info.PublicKey, err = parseECDSAPublicKey(record.PublicKey); err != nil {
+				return fmt.Errorf("%s: public_key of %s: %w", s.path, record.AccessKey, err)
+			}
+		}
+		keys[record.AccessKey] = info
+	}
+
+	s.replace(keys)
+	s.modTime, s.size = stat.ModTime(), stat.Size()
+	return nil
+}
+
+// Watch polls the file every interval and reloads it when it changes, until ctx is done.
+// Reload errors go to onError if not nil.
+func (s *FileKeyStore) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := s.changed()
+		if changed {
+			err = s.Reload()
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+func (s *FileKeyStore) changed() (bool, error) {
+	stat, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return !stat.ModTime().Equal(s.modTime) || stat.Size() != s.size, nil
+}
+
+func parseECDSAPublicKey(content string) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(content))
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an ecdsa public key: %T", pub)
+	}
+	return ecdsaPub, nil
+}
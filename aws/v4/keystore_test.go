@@ -0,0 +1,115 @@
+package v4
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileKeyStore(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	jsonPath := filepath.Join(dir, "keys.json")
+	assert.NoError(t, os.WriteFile(jsonPath, []byte(`{"keys": [{"access_key": "spiderman", "secret_key": "@C*u0NrTxs@Y89m#", "metadata": {"owner": "peter"}}]}`), 0o600))
+	s, err := NewFileKeyStore(jsonPath)
+	assert.NoError(t, err)
+	info, err := s.LookupKey(ctx, "spiderman")
+	assert.NoError(t, err)
+	assert.Equal(t, "peter", info.Metadata["owner"])
+
+	// the Metadata of an Authorization is a copy
+	req := httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4(req, &info.Key, "universal", "s3")
+	assert.NoError(t, err)
+	a, _, err := CheckRequestWithAwsV4KeyStore(req, s, "universal", "s3")
+	assert.NoError(t, err)
+	a.Metadata["owner"] = "mallory"
+	assert.Equal(t, "peter", info.Metadata["owner"])
+
+	yamlPath := filepath.Join(dir, "keys.yaml")
+	assert.NoError(t, os.WriteFile(yamlPath, []byte("keys:\n  - access_key: batman\n    secret_key: secret\n"), 0o600))
+	s, err = NewFileKeyStore(yamlPath)
+	assert.NoError(t, err)
+	_, err = s.LookupKey(ctx, "spiderman")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.Watch(watchCtx, 10*time.Millisecond, nil)
+
+	assert.NoError(t, os.WriteFile(yamlPath, []byte("keys:\n  - access_key: batman\n    secret_key: secret\n  - access_key: spiderman\n    secret_key: secret\n"), 0o600))
+	assert.Eventually(t, func() bool {
+		_, err := s.LookupKey(ctx, "spiderman")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFileKeyStore_PublicKey(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	region, name := "us-east-1", "iam"
+
+	path := filepath.Join(dir, "keys.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"keys": [{"access_key": "nokey"}]}`), 0o600))
+	_, err := NewFileKeyStore(path)
+	assert.ErrorContains(t, err, "neither secret_key nor public_key")
+
+	signer := &Key{AccessKey: "v4a_key_id", SecretKey: "some_secret"}
+	public, err := signer.ECDSAPublicKey()
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(public)
+	assert.NoError(t, err)
+	record, err := json.Marshal(map[string]string{
+		"access_key": signer.AccessKey,
+		"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, []byte(`{"keys": [`+string(record)+`]}`), 0o600))
+	s, err := NewFileKeyStore(path)
+	assert.NoError(t, err)
+	info, err := s.LookupKey(ctx, signer.AccessKey)
+	assert.NoError(t, err)
+	assert.True(t, info.SigV4aOnly)
+
+	req := httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4(req, signer, region, name, WithSigV4a())
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4KeyStore(req, s, region, name)
+	assert.NoError(t, err)
+
+	// a key only for SigV4a is unknown to AWS4-HMAC-SHA256, even when the store holds its secret key
+	info.SecretKey = signer.SecretKey
+	req = httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4(req, signer, region, name)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4KeyStore(req, s, region, name)
+	assert.ErrorIs(t, err, ErrUnknownAccessKey)
+}
+
+func TestCheckRequestWithAwsV4KeyStore(t *testing.T) {
+	region, name := "universial", "query_api"
+	key := &Key{
+		AccessKey: "spiderman",
+		SecretKey: "@C*u0NrTxs@Y89m#",
+	}
+	store := NewMemoryKeyStore(&KeyInfo{Key: *key})
+
+	req := httptestRequest(t, "http://localhost:9527/app")
+	_, err := SignRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4KeyStore(req, store, region, name)
+	assert.NoError(t, err)
+
+	store.Remove(key.AccessKey)
+	assert.NoError(t, store.Add(&KeyInfo{Key: *key, Disabled: true}))
+	_, _, err = CheckRequestWithAwsV4KeyStore(req, store, region, name)
+	assert.Error(t, err)
+}
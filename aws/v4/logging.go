@@ -0,0 +1,96 @@
+package v4
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// reasons in the order Reason tests them, an unknown key is also a signature mismatch
+var reasons = []error{
+	ErrUnknownAccessKey,
+	ErrMalformedAuthorization,
+	ErrUnsupportedAlgorithm,
+	ErrScopeMismatch,
+	ErrInvalidDate,
+	ErrRequestTimeSkewed,
+	ErrExpired,
+	ErrInvalidSecurityToken,
+	ErrSignatureMismatch,
+	ErrInvalidPayload,
+}
+
+// Reason is the message of the sentinel error err wraps, without the details of the request.
+// It is empty for a nil err and err.Error() for other errors.
+func Reason(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, reason := range reasons {
+		if errors.Is(err, reason) {
+			return reason.Error()
+		}
+	}
+	return err.Error()
+}
+
+// Scope is the credential without the access key, e.g. 20150830/us-east-1/iam/aws4_request
+func (a *Authorization) Scope() string {
+	if i := strings.IndexByte(a.Credential, '/'); i >= 0 {
+		return a.Credential[i+1:]
+	}
+	return ""
+}
+
+/*
+AuthAttrs are the fields of an auth decision: access_key, scope, outcome (allow or deny),
+reason, latency and client_ip. a may be nil when the authorization can not be parsed.
+Signatures and secrets are never among them, reason comes from Reason.
+*/
+func AuthAttrs(a *Authorization, err error, latency time.Duration, clientIP string) []slog.Attr {
+	var accessKey, scope string
+	if a != nil {
+		accessKey, scope = a.AccessKeyID, a.Scope()
+	}
+	outcome := "allow"
+	if err != nil {
+		outcome = "deny"
+	}
+	return []slog.Attr{
+		slog.String("access_key", accessKey),
+		slog.String("scope", scope),
+		slog.String("outcome", outcome),
+		slog.String("reason", Reason(err)),
+		slog.Duration("latency", latency),
+		slog.String("client_ip", clientIP),
+	}
+}
+
+// LogAuth logs an auth decision with AuthAttrs, at info level when allowed and warn level when denied
+func LogAuth(ctx context.Context, logger *slog.Logger, a *Authorization, err error, latency time.Duration, clientIP string) {
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	logger.LogAttrs(ctx, level, "aws v4 auth", AuthAttrs(a, err, latency, clientIP)...)
+}
+
+// logDecision is deferred by the Check* functions
+func (o *checkOptions) logDecision(req *http.Request, a **Authorization, err *error, start time.Time) {
+	if o.logger == nil {
+		return
+	}
+	LogAuth(req.Context(), o.logger, *a, *err, time.Since(start), remoteIP(req))
+}
+
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
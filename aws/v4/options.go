@@ -0,0 +1,287 @@
+package v4
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// SignOption customizes SignRequestWithAwsV4 and SignRequestWithAwsV4UseQueryString
+type SignOption func(*signOptions)
+
+type signOptions struct {
+	expires     time.Duration
+	chunkSize   int
+	payloadHash string
+	sigV4a      bool
+	regionSet   []string
+	signHeader  func(header string) bool
+	s3Path      bool
+	headerMode  HeaderMode
+}
+
+func newSignOptions(opts []SignOption) *signOptions {
+	o := &signOptions{
+		expires:    DefaultPresignExpires,
+		signHeader: defaultSignedHeader,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// hopByHopHeaders may be changed by proxies and are never signed
+var hopByHopHeaders = map[string]bool{
+	"connection":          true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// defaultSignedHeader selects the headers the AWS SDKs sign: host, x-amz-*, content-type and content-md5
+func defaultSignedHeader(header string) bool {
+	return strings.HasPrefix(header, "x-amz-") || header == "content-type" || header == "content-md5"
+}
+
+// WithSignedHeaders signs only host, x-amz-* and headers, the allowlist replaces the default set
+func WithSignedHeaders(headers ...string) SignOption {
+	selected := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		selected[strings.ToLower(header)] = true
+	}
+	return func(o *signOptions) {
+		o.signHeader = func(header string) bool {
+			return selected[header] || strings.HasPrefix(header, "x-amz-")
+		}
+	}
+}
+
+// WithUnsignedHeaders signs every header but headers and hop-by-hop ones, the denylist replaces the default set
+func WithUnsignedHeaders(headers ...string) SignOption {
+	excluded := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		excluded[strings.ToLower(header)] = true
+	}
+	return func(o *signOptions) {
+		o.signHeader = func(header string) bool {
+			return !excluded[header] && !hopByHopHeaders[header]
+		}
+	}
+}
+
+// WithExpires sets X-Amz-Expires for query string signing, at most MaxPresignExpires
+func WithExpires(d time.Duration) SignOption {
+	return func(o *signOptions) {
+		o.expires = d
+	}
+}
+
+// WithChunkedPayload makes SignRequestWithAwsV4 send the body as signed aws-chunked
+// (STREAMING-AWS4-HMAC-SHA256-PAYLOAD) of size bytes per chunk, req.ContentLength must be known.
+// Values <= 0 fall back to DefaultChunkSize.
+func WithChunkedPayload(size int) SignOption {
+	return func(o *signOptions) {
+		if size <= 0 {
+			size = DefaultChunkSize
+		}
+		o.chunkSize = size
+	}
+}
+
+// WithPayloadHash sends hash as the signed x-amz-content-sha256 header and signs it
+// instead of reading the body, hash is the hex encoded sha256 of the body
+func WithPayloadHash(hash string) SignOption {
+	return func(o *signOptions) {
+		o.payloadHash = hash
+	}
+}
+
+// WithUnsignedPayload leaves the body out of the signature with x-amz-content-sha256: UNSIGNED-PAYLOAD
+func WithUnsignedPayload() SignOption {
+	return WithPayloadHash(UnsignedPayload)
+}
+
+// WithSigV4a signs with AWS4-ECDSA-P256-SHA256 for the region set, the region argument if empty.
+// "*" stands for all regions.
+func WithSigV4a(regionSet ...string) SignOption {
+	return func(o *signOptions) {
+		o.sigV4a = true
+		o.regionSet = regionSet
+	}
+}
+
+// WithS3PathEncoding neither normalizes the path nor encodes it twice, as Amazon S3 requires
+func WithS3PathEncoding() SignOption {
+	return func(o *signOptions) {
+		o.s3Path = true
+	}
+}
+
+// WithHeaderMode canonicalizes headers the HeaderModeLegacy way for servers not updated yet,
+// HeaderModeSpec by default
+func WithHeaderMode(mode HeaderMode) SignOption {
+	return func(o *signOptions) {
+		o.headerMode = mode
+	}
+}
+
+func (o *signOptions) canonical(region string) *canonical {
+	c := &canonical{signHeader: o.signHeader, s3Path: o.s3Path, headerMode: o.headerMode}
+	if o.sigV4a {
+		c.algorithm = aws4EcdsaP256Sha256Algorithm
+		if len(o.regionSet) == 0 {
+			o.regionSet = []string{region}
+		}
+	}
+	return c
+}
+
+// CheckOption customizes CheckRequestWithAwsV4 and CheckRequestWithAwsV4KeyMaps
+type CheckOption func(*checkOptions)
+
+type checkOptions struct {
+	maxExpires time.Duration
+	maxSkew    time.Duration
+	now        func() time.Time
+
+	allowUnsignedPayload  bool
+	sessionTokenValidator SessionTokenValidator
+	signingKeyCache       *SigningKeyCache
+	disclosure            DisclosureMode
+	logger                *slog.Logger
+	s3Path                bool
+	headerModes           []HeaderMode
+	requiredSignedHeaders []string
+}
+
+func newCheckOptions(opts []CheckOption) *checkOptions {
+	o := &checkOptions{
+		maxExpires: MaxPresignExpires,
+		maxSkew:    DefaultMaxSkew,
+		now:        time.Now,
+
+		signingKeyCache: defaultSigningKeyCache,
+		headerModes:     []HeaderMode{HeaderModeSpec},
+
+		requiredSignedHeaders: DefaultRequiredSignedHeaders(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMaxExpires caps the X-Amz-Expires accepted on query string requests.
+// Values not in (0, MaxPresignExpires] fall back to MaxPresignExpires.
+func WithMaxExpires(d time.Duration) CheckOption {
+	return func(o *checkOptions) {
+		if d <= 0 || d > MaxPresignExpires {
+			d = MaxPresignExpires
+		}
+		o.maxExpires = d
+	}
+}
+
+// WithMaxSkew sets the clock skew allowed for header signed requests.
+// Values <= 0 fall back to DefaultMaxSkew.
+func WithMaxSkew(d time.Duration) CheckOption {
+	return func(o *checkOptions) {
+		if d <= 0 {
+			d = DefaultMaxSkew
+		}
+		o.maxSkew = d
+	}
+}
+
+// WithAllowUnsignedPayload accepts x-amz-content-sha256: UNSIGNED-PAYLOAD, rejected by default
+// except for presigned urls
+func WithAllowUnsignedPayload(allow bool) CheckOption {
+	return func(o *checkOptions) {
+		o.allowUnsignedPayload = allow
+	}
+}
+
+// SessionTokenValidator rejects a missing, expired or mismatched X-Amz-Security-Token of accessKey,
+// token is empty if the request has none
+type SessionTokenValidator func(ctx context.Context, accessKey, token string) error
+
+// WithSessionTokenValidator checks X-Amz-Security-Token once the signature matches.
+// Without it, a token is only required when the Key has a SessionToken.
+func WithSessionTokenValidator(v SessionTokenValidator) CheckOption {
+	return func(o *checkOptions) {
+		o.sessionTokenValidator = v
+	}
+}
+
+// WithSigningKeyCache replaces the package wide cache of derived signing keys,
+// nil falls back to it and NewSigningKeyCache(0) disables caching
+func WithSigningKeyCache(c *SigningKeyCache) CheckOption {
+	return func(o *checkOptions) {
+		if c == nil {
+			c = defaultSigningKeyCache
+		}
+		o.signingKeyCache = c
+	}
+}
+
+// WithDisclosure sets how much a signature mismatch error tells, DisclosureNone by default
+func WithDisclosure(mode DisclosureMode) CheckOption {
+	return func(o *checkOptions) {
+		o.disclosure = mode
+	}
+}
+
+// WithS3PathEncodingCheck expects paths signed with WithS3PathEncoding
+func WithS3PathEncodingCheck() CheckOption {
+	return func(o *checkOptions) {
+		o.s3Path = true
+	}
+}
+
+// WithHeaderModes accepts a signature made with any of modes, tried in order.
+// WithHeaderModes(HeaderModeSpec, HeaderModeLegacy) lets clients migrate, empty keeps HeaderModeSpec only.
+func WithHeaderModes(modes ...HeaderMode) CheckOption {
+	return func(o *checkOptions) {
+		if len(modes) > 0 {
+			o.headerModes = modes
+		}
+	}
+}
+
+// DefaultRequiredSignedHeaders returns the headers which must be signed unless WithRequiredSignedHeaders
+// says otherwise, x-amz-date stands for the date header of the request, see WithRequiredSignedHeaders.
+// It is a new slice on every call, the callers may append to it.
+func DefaultRequiredSignedHeaders() []string {
+	return []string{headKeyHost, headKeyXAmzDate}
+}
+
+// WithRequiredSignedHeaders replaces DefaultRequiredSignedHeaders, a request not signing all of headers is rejected.
+// x-amz-date is also met by a signed date header when there is no x-amz-date, and by query string requests.
+// Add to DefaultRequiredSignedHeaders rather than dropping it, or a signature may be replayed to another host or time.
+func WithRequiredSignedHeaders(headers ...string) CheckOption {
+	return func(o *checkOptions) {
+		o.requiredSignedHeaders = append([]string(nil), headers...)
+	}
+}
+
+// WithLogger logs every decision of the Check* functions, see AuthAttrs for the fields
+func WithLogger(logger *slog.Logger) CheckOption {
+	return func(o *checkOptions) {
+		o.logger = logger
+	}
+}
+
+// WithClock replaces time.Now, mostly for tests
+func WithClock(now func() time.Time) CheckOption {
+	return func(o *checkOptions) {
+		if now != nil {
+			o.now = now
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package v4
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PresignOptions for Presign
+type PresignOptions struct {
+	Credentials CredentialsProvider
+	Region      string
+	Name        string
+	// Header will be sent with the request, x-amz-* headers are moved into the query
+	// except x-amz-content-sha256 and x-amz-server-side-encryption-customer-*
+	Header http.Header
+	// SignedHeaders lists the headers of Header to sign besides host, all of them if nil.
+	// Leave out headers a browser will not send as they are.
+	SignedHeaders []string
+	// SignOptions are passed to SignRequestWithAwsV4UseQueryString, e.g. WithPayloadHash or WithSigV4a
+	SignOptions []SignOption
+}
+
+// Presign is PresignWithContext with context.Background
+func Presign(method, url string, expires time.Duration, opts *PresignOptions) (string, http.Header, error) {
+	return PresignWithContext(context.Background(), method, url, expires, opts)
+}
+
+/*
+PresignWithContext returns a url valid for expires (at most MaxPresignExpires),
+and the signed headers the caller must still send with it.
+https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+*/
+func PresignWithContext(ctx context.Context, method, url string, expires time.Duration, opts *PresignOptions) (string, http.Header, error) {
+	if opts == nil || opts.Credentials == nil {
+		return "", nil, fmt.Errorf("presign needs credentials")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := req.URL.Query()
+	for k, vs := range opts.Header {
+		if hoistHeader(k) {
+			query[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+			continue
+		}
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.URL.RawQuery = query.Encode()
+
+	// all of Header is signed unless SignOptions or SignedHeaders say otherwise
+	signAll := func(o *signOptions) {
+		o.signHeader = func(string) bool { return true }
+	}
+	signOpts := append([]SignOption{WithExpires(expires), signAll}, opts.SignOptions...)
+	if opts.SignedHeaders != nil {
+		selected := make(map[string]bool, len(opts.SignedHeaders))
+		for _, header := range opts.SignedHeaders {
+			selected[strings.ToLower(header)] = true
+		}
+		signOpts = append(signOpts, func(o *signOptions) {
+			o.signHeader = func(header string) bool {
+				return selected[header] || header == headKeyContentSHA256
+			}
+		})
+	}
+	if _, err = SignRequestWithAwsV4UseQueryString(req, opts.Credentials, opts.Region, opts.Name, signOpts...); err != nil {
+		return "", nil, err
+	}
+
+	headers := make(http.Header)
+	for _, name := range strings.Split(req.URL.Query().Get(queryKeySignatureHeaders), ";") {
+		if name != headKeyHost && name != "" {
+			headers[http.CanonicalHeaderKey(name)] = req.Header.Values(name)
+		}
+	}
+	return req.URL.String(), headers, nil
+}
+
+// hoistHeader reports whether a header is moved into the query, same as the AWS SDKs
+func hoistHeader(header string) bool {
+	header = strings.ToLower(header)
+	return strings.HasPrefix(header, "x-amz-") &&
+		header != headKeyContentSHA256 &&
+		!strings.HasPrefix(header, "x-amz-server-side-encryption-customer-")
+}
@@ -0,0 +1,86 @@
+package v4
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresign(t *testing.T) {
+	region, name := "universial", "query_api"
+	key := &Key{
+		AccessKey: "spiderman",
+		SecretKey: "@C*u0NrTxs@Y89m#",
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain")
+	header.Set("User-Agent", "presign-test")
+	header.Set("X-Amz-Meta-Owner", "peter")
+
+	signedURL, headers, err := Presign("PUT", "http://localhost:9527/upload?part=1", time.Hour, &PresignOptions{
+		Credentials:   key,
+		Region:        region,
+		Name:          name,
+		Header:        header,
+		SignedHeaders: []string{"content-type"},
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, signedURL, "X-Amz-Meta-Owner=peter")
+	assert.Contains(t, signedURL, "X-Amz-Expires=3600")
+	assert.Equal(t, http.Header{"Content-Type": {"text/plain"}}, headers)
+
+	req, err := http.NewRequest("PUT", signedURL, strings.NewReader("hello"))
+	assert.NoError(t, err)
+	for k, vs := range headers {
+		req.Header[k] = vs
+	}
+	req.Header.Set("User-Agent", "browser")
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+
+	_, _, err = Presign("GET", "http://localhost:9527/app", 8*24*time.Hour, &PresignOptions{Credentials: key})
+	assert.Error(t, err)
+}
+
+func TestPresign_Body(t *testing.T) {
+	region, name := "universial", "query_api"
+	key := &Key{
+		AccessKey: "spiderman",
+		SecretKey: "@C*u0NrTxs@Y89m#",
+	}
+	upload := func(signedURL string, headers http.Header, body string) error {
+		req, err := http.NewRequest("POST", signedURL, strings.NewReader(body))
+		assert.NoError(t, err)
+		for k, vs := range headers {
+			req.Header[k] = vs
+		}
+		_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+		return err
+	}
+
+	for _, opts := range [][]SignOption{nil, {WithUnsignedPayload()}, {WithSigV4a()}} {
+		signedURL, headers, err := Presign("POST", "http://localhost:9527/upload", time.Hour, &PresignOptions{
+			Credentials: key,
+			Region:      region,
+			Name:        name,
+			SignOptions: opts,
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, upload(signedURL, headers, "hello"))
+	}
+
+	// the body is signed when its hash is given
+	signedURL, headers, err := Presign("POST", "http://localhost:9527/upload", time.Hour, &PresignOptions{
+		Credentials: key,
+		Region:      region,
+		Name:        name,
+		SignOptions: []SignOption{WithPayloadHash(hex.EncodeToString(gsha256([]byte("hello"))))},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, upload(signedURL, headers, "hello"))
+	assert.ErrorIs(t, upload(signedURL, headers, "bye"), ErrInvalidPayload)
+}
@@ -1,70 +1,276 @@
 package v4
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"sync"
 	"time"
 )
 
 // CheckRequestWithAwsV4 runs for server
-func CheckRequestWithAwsV4(req *http.Request, key *Key, region, name string) (a *Authorization, sp *SignProcess, err error) {
+//
+// a body signed as STREAMING-AWS4-HMAC-SHA256-PAYLOAD is not read here,
+// req.Body is replaced with a reader that verifies every chunk while it is read
+func CheckRequestWithAwsV4(req *http.Request, key *Key, region, name string, opts ...CheckOption) (a *Authorization, sp *SignProcess, err error) {
+	o := newCheckOptions(opts)
+	defer o.logDecision(req, &a, &err, time.Now())
 	if a, err = NewAuthorization(req); err != nil {
 		return
 	}
 
-	var t time.Time
-	if t, err = a.Check(req, region, name); err != nil {
-		return
-	}
+	sp, err = checkRequest(req, a, key, region, name, o)
+	return
+}
 
-	sp = new(SignProcess)
-	sp.Key = key.Sign(t, region, name)
+// CheckRequestWithAwsV4KeyMaps runs for server, same as CheckRequestWithAwsV4
+func CheckRequestWithAwsV4KeyMaps(req *http.Request, keys map[string]string, region, name string, opts ...CheckOption) (a *Authorization, sp *SignProcess, err error) {
+	return CheckRequestWithAwsV4KeyStore(req, KeyMap(keys), region, name, opts...)
+}
 
-	writeStringToSign(t, req, a, sp, true, region, name)
-	result := hex.EncodeToString(sp.AllSHA256)
+// CheckRequestWithAwsV4KeyStore runs for server, the key is looked up in store
+func CheckRequestWithAwsV4KeyStore(req *http.Request, store KeyStore, region, name string, opts ...CheckOption) (a *Authorization, sp *SignProcess, err error) {
+	o := newCheckOptions(opts)
+	defer o.logDecision(req, &a, &err, time.Now())
+	if a, err = NewAuthorization(req); err != nil {
+		return
+	}
 
-	if a.Signature != result {
-		fmt.Println(sp)
-		err = fmt.Errorf("awsv4 check faild. expected: %s, got: %s", a.Signature, result)
+	var info *KeyInfo
+	if info, err = store.LookupKey(req.Context(), a.AccessKeyID); err != nil {
+		if !errors.Is(err, ErrKeyNotFound) {
+			return
+		}
+		info = nil
+	}
+	if info == nil || info.Disabled || (info.SigV4aOnly && a.Algorithm != aws4EcdsaP256Sha256Algorithm) {
+		sp, err = checkUnknownKey(req, a, region, name, o)
 		return
 	}
+	key := info.Key
+	key.AccessKey = a.AccessKeyID
 
+	if sp, err = checkRequest(req, a, &key, region, name, o); err == nil {
+		// a copy, the map of the store is shared by every request of the key
+		a.Metadata = maps.Clone(info.Metadata)
+	}
 	return
 }
 
-// CheckRequestWithAwsV4KeyMaps runs for server
-func CheckRequestWithAwsV4KeyMaps(req *http.Request, keys map[string]string, region, name string) (a *Authorization, sp *SignProcess, err error) {
-	if a, err = NewAuthorization(req); err != nil {
+var (
+	dummyKeyOnce sync.Once
+	dummyKey     *Key
+)
+
+/*
+checkUnknownKey does the same work as checkRequest with a random key,
+so an unknown, disabled or expired access key takes as long
+and fails with the same message as a bad signature.
+errors.Is tells ErrUnknownAccessKey apart on the server.
+*/
+func checkUnknownKey(req *http.Request, a *Authorization, region, name string, o *checkOptions) (*SignProcess, error) {
+	dummyKeyOnce.Do(func() {
+		secret := make([]byte, 32)
+		_, _ = rand.Read(secret)
+		private, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		dummyKey = &Key{
+			// a fixed access key keeps a single entry in the signing key cache
+			AccessKey: "unknown",
+			SecretKey: hex.EncodeToString(secret),
+			PublicKey: &private.PublicKey,
+		}
+	})
+
+	sp, err := checkRequest(req, a, dummyKey, region, name, o)
+	var mismatch *SignatureMismatchError
+	if errors.As(err, &mismatch) {
+		mismatch.unknownKey = true
+		return sp, mismatch
+	}
+	if err == nil {
+		// a random key can not match, be safe anyway
+		sp, err = signatureMismatch(sp, o)
+		err.(*SignatureMismatchError).unknownKey = true
+	}
+	return sp, err
+}
+
+func checkRequest(req *http.Request, a *Authorization, key *Key, region, name string, o *checkOptions) (sp *SignProcess, err error) {
+	if key.Expired(o.now()) || !key.verifies(a.Algorithm) {
+		return checkUnknownKey(req, a, region, name, o)
+	}
+
+	var t time.Time
+	if t, err = a.check(req, region, name, o); err != nil {
 		return
 	}
 
-	secretKey, ok := keys[a.AccessKeyID]
-	if !ok {
-		err = fmt.Errorf("access key id: [%s] is not supported", a.AccessKeyID)
+	c := &canonical{algorithm: a.Algorithm, s3Path: o.s3Path}
+	if c.payloadHash, err = payloadHash(req, a, o); err != nil {
 		return
 	}
-	key := &Key{
-		AccessKey: a.AccessKeyID,
-		SecretKey: secretKey,
+	verify := verifyV4
+	if c.isV4a() {
+		if c.payloadHash == StreamingPayload {
+			err = fmt.Errorf("%w: chunked payload is not supported by %s", ErrInvalidPayload, aws4EcdsaP256Sha256Algorithm)
+			return
+		}
+		verify = verifyV4a
 	}
-
-	var t time.Time
-	if t, err = a.Check(req, region, name); err != nil {
+	// a presigned url without x-amz-content-sha256 is signed with UNSIGNED-PAYLOAD by S3 clients,
+	// and with the hash of the body by the other services
+	payloads := []string{c.payloadHash}
+	if a.byQuery && c.payloadHash == "" {
+		payloads = []string{UnsignedPayload, ""}
+	}
+	// the signature may match any of the payloads and header modes, the last mismatch is returned
+verify:
+	for _, payload := range payloads {
+		c.payloadHash = payload
+		for _, mode := range o.headerModes {
+			c.headerMode = mode
+			if sp, err = verify(t, req, a, key, c, o, region, name); !errors.Is(err, ErrSignatureMismatch) {
+				break verify
+			}
+		}
+	}
+	if err != nil {
+		return
+	}
+	if err = checkSessionToken(req, a, key, o); err != nil {
 		return
 	}
 
+	switch c.payloadHash {
+	case "", UnsignedPayload:
+	case StreamingPayload:
+		err = unwrapChunked(req, newChunkSigner(sp.Key, t, region, name, a.Signature))
+	default:
+		if err = hashBody(req, sp); err != nil {
+			err = invalidBody(err)
+		} else if hex.EncodeToString(sp.BodySHA256) != c.payloadHash {
+			err = fmt.Errorf("%w: %s does not match the body", ErrInvalidPayload, headKeyContentSHA256)
+		}
+	}
+	return
+}
+
+// verifies reports whether the key can check a signature of algorithm.
+// HMAC needs the secret key, a key derived from an empty one is known to everyone,
+// so a key with only a public key checks AWS4-ECDSA-P256-SHA256 alone.
+func (k *Key) verifies(algorithm string) bool {
+	if algorithm == aws4EcdsaP256Sha256Algorithm {
+		return k.PublicKey != nil || k.SecretKey != ""
+	}
+	return k.SecretKey != ""
+}
+
+// signatureEqual compares in constant time. Only the exact lower case hex form matches,
+// the replay protection depends on a signature having a single spelling.
+func signatureEqual(expected []byte, signature string) bool {
+	if !isSHA256Hex(signature) {
+		return false
+	}
+	got, _ := hex.DecodeString(signature)
+	return hmac.Equal(expected, got)
+}
+
+// checkSessionToken runs once the signature matches, so a wrong token does not tell a known key from an unknown one
+func checkSessionToken(req *http.Request, a *Authorization, key *Key, o *checkOptions) error {
+	token := req.Header.Get(headKeySecurityToken)
+	if a.byQuery {
+		token = req.URL.Query().Get(queryKeySecurityToken)
+	} else if token != "" && !a.containsSignedHeader(headKeySecurityToken) {
+		return fmt.Errorf("%w: header %s must be signed", ErrMalformedAuthorization, headKeySecurityToken)
+	}
+
+	if o.sessionTokenValidator != nil {
+		err := o.sessionTokenValidator(req.Context(), a.AccessKeyID, token)
+		if err != nil && !errors.Is(err, ErrInvalidSecurityToken) {
+			err = fmt.Errorf("%w: %w", ErrInvalidSecurityToken, err)
+		}
+		return err
+	}
+	if key.SessionToken != "" && !hmac.Equal([]byte(token), []byte(key.SessionToken)) {
+		return fmt.Errorf("%w of access key id: [%s]", ErrInvalidSecurityToken, a.AccessKeyID)
+	}
+	return nil
+}
+
+// verifyV4 checks the hmac signature, the signing key is derived once a day
+func verifyV4(t time.Time, req *http.Request, a *Authorization, key *Key, c *canonical, o *checkOptions, region, name string) (sp *SignProcess, err error) {
 	sp = new(SignProcess)
-	sp.Key = key.Sign(t, region, name)
+	sp.Key = o.signingKeyCache.Sign(key, t, region, name)
+
+	if err = writeStringToSign(t, req, a, sp, c, true, region, name); err != nil {
+		return nil, invalidBody(err)
+	}
 
-	writeStringToSign(t, req, a, sp, true, region, name)
-	result := hex.EncodeToString(sp.AllSHA256)
+	if !signatureEqual(sp.AllSHA256, a.Signature) {
+		sp, err = signatureMismatch(sp, o)
+	}
+	return
+}
 
-	if a.Signature != result {
-		fmt.Println(sp)
-		err = fmt.Errorf("awsv4 check faild. expected: %s, got: %s", a.Signature, result)
+// verifyV4a checks the ecdsa signature with the public key only, one derived from the secret key is cached
+func verifyV4a(t time.Time, req *http.Request, a *Authorization, key *Key, c *canonical, o *checkOptions, region, name string) (sp *SignProcess, err error) {
+	pub := key.PublicKey
+	if pub == nil {
+		var priv *ecdsa.PrivateKey
+		if priv, err = o.signingKeyCache.ECDSAKey(key); err != nil {
+			return
+		}
+		pub = &priv.PublicKey
+	}
+	var signature []byte
+	if signature, err = hex.DecodeString(a.Signature); err != nil {
+		err = fmt.Errorf("%w: invalid signature: %s", ErrMalformedAuthorization, a.Signature)
 		return
 	}
 
+	sp = new(SignProcess)
+	if err = writeStringToSign(t, req, a, sp, c, true, region, name); err != nil {
+		return nil, invalidBody(err)
+	}
+	sp.AllSHA256 = signature
+	if !ecdsa.VerifyASN1(pub, gsha256(sp.All), signature) {
+		sp, err = signatureMismatch(sp, o)
+	}
 	return
 }
+
+// invalidBody is returned when the body can not be read, e.g. the client went away
+func invalidBody(err error) error {
+	return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+}
+
+/*
+payloadHash returns the signed x-amz-content-sha256, empty if the body should be hashed
+https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+*/
+func payloadHash(req *http.Request, a *Authorization, o *checkOptions) (string, error) {
+	hash := req.Header.Get(headKeyContentSHA256)
+	if hash == "" {
+		return "", nil
+	}
+	if !a.containsSignedHeader(headKeyContentSHA256) {
+		return "", fmt.Errorf("%w: header %s must be signed", ErrMalformedAuthorization, headKeyContentSHA256)
+	}
+	switch {
+	case hash == StreamingPayload:
+	case hash == UnsignedPayload:
+		// the body of a presigned url is not known when it is signed
+		if !o.allowUnsignedPayload && !a.byQuery {
+			return "", fmt.Errorf("%w: %s is not allowed", ErrInvalidPayload, UnsignedPayload)
+		}
+	case !isSHA256Hex(hash):
+		return "", fmt.Errorf("%w: invalid %s: %s", ErrInvalidPayload, headKeyContentSHA256, hash)
+	}
+	return hash, nil
+}
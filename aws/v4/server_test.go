@@ -1,9 +1,17 @@
 package v4
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -21,8 +29,13 @@ func TestCheckRequestWithAwsV4_Official(t *testing.T) {
 	assert.NoError(t, err)
 	req.Header.Set("content-type", `application/x-www-form-urlencoded; charset=utf-8`)
 
-	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	now := func() time.Time { return time.Date(2015, 8, 30, 12, 36, 30, 0, time.UTC) }
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithClock(now))
 	assert.NoError(t, err)
+
+	later := func() time.Time { return time.Date(2015, 8, 30, 12, 37, 1, 0, time.UTC) }
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithClock(later))
+	assert.Error(t, err)
 }
 
 func TestCheckRequestWithAwsV4(t *testing.T) {
@@ -137,3 +150,449 @@ func TestCheckRequestWithAwsV4_WithQueryString(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckRequestWithAwsV4_Expires(t *testing.T) {
+	region, name := "universial", "query_api"
+	key := &Key{
+		AccessKey: "spiderman",
+		SecretKey: "@C*u0NrTxs@Y89m#",
+	}
+	url := "http://localhost:9527/app"
+
+	_, err := SignRequestWithAwsV4UseQueryString(httptestRequest(t, url), key, region, name, WithExpires(MaxPresignExpires+time.Second))
+	assert.Error(t, err)
+
+	req := httptestRequest(t, url)
+	_, err = SignRequestWithAwsV4UseQueryString(req, key, region, name, WithExpires(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "3600", req.URL.Query().Get("X-Amz-Expires"))
+
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithMaxExpires(time.Minute))
+	assert.Error(t, err)
+
+	later := func() time.Time { return time.Now().Add(time.Hour + time.Minute) }
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithClock(later))
+	assert.Error(t, err)
+
+	// signed one year ahead
+	req = httptestRequest(t, url)
+	req.Header.Set("Date", time.Now().AddDate(1, 0, 0).UTC().Format(http.TimeFormat))
+	_, err = SignRequestWithAwsV4UseQueryString(req, key, region, name, WithExpires(time.Hour))
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithMaxExpires(time.Hour))
+	assert.ErrorIs(t, err, ErrRequestTimeSkewed)
+}
+
+func httptestRequest(t *testing.T, url string) *http.Request {
+	req, err := http.NewRequest("GET", url, nil)
+	assert.NoError(t, err)
+	return req
+}
+
+func TestCheckRequestWithAwsV4_Skew(t *testing.T) {
+	region, name := "universial", "query_api"
+	key := &Key{
+		AccessKey: "spiderman",
+		SecretKey: "@C*u0NrTxs@Y89m#",
+	}
+	req := httptestRequest(t, "http://localhost:9527/app")
+	_, err := SignRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+
+	later := func() time.Time { return time.Now().Add(DefaultMaxSkew + time.Minute) }
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithClock(later))
+	var skewErr *SkewError
+	assert.ErrorAs(t, err, &skewErr)
+
+	_, _, err = CheckRequestWithAwsV4KeyMaps(req, map[string]string{key.AccessKey: key.SecretKey}, region, name,
+		WithClock(later), WithMaxSkew(time.Hour))
+	assert.NoError(t, err)
+}
+
+func TestCheckRequestWithAwsV4_PayloadHash(t *testing.T) {
+	region, name := "universial", "query_api"
+	key := &Key{
+		AccessKey: "spiderman",
+		SecretKey: "@C*u0NrTxs@Y89m#",
+	}
+	url := "http://localhost:9527/app"
+	bodyStr := `{"id": 1}`
+	hash := hex.EncodeToString(gsha256([]byte(bodyStr)))
+
+	req, err := http.NewRequest("POST", url, strings.NewReader(bodyStr))
+	assert.NoError(t, err)
+	_, err = SignRequestWithAwsV4(req, key, region, name, WithPayloadHash(hash))
+	assert.NoError(t, err)
+	assert.Equal(t, hash, req.Header.Get("X-Amz-Content-Sha256"))
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+
+	// signed digest does not match the body
+	req, err = http.NewRequest("POST", url, strings.NewReader(`{"id": 2}`))
+	assert.NoError(t, err)
+	_, err = SignRequestWithAwsV4(req, key, region, name, WithPayloadHash(hash))
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.Error(t, err)
+
+	req, err = http.NewRequest("POST", url, strings.NewReader(bodyStr))
+	assert.NoError(t, err)
+	_, err = SignRequestWithAwsV4(req, key, region, name, WithUnsignedPayload())
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.Error(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithAllowUnsignedPayload(true))
+	assert.NoError(t, err)
+
+	// the client went away in the middle of the body
+	for _, opts := range [][]SignOption{nil, {WithPayloadHash(hash)}} {
+		req, err = http.NewRequest("POST", url, strings.NewReader(bodyStr))
+		assert.NoError(t, err)
+		_, err = SignRequestWithAwsV4(req, key, region, name, opts...)
+		assert.NoError(t, err)
+		req.Body = io.NopCloser(io.MultiReader(strings.NewReader(bodyStr[:3]), iotest.ErrReader(io.ErrUnexpectedEOF)))
+		_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+		assert.ErrorIs(t, err, ErrInvalidPayload)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestCheckRequestWithAwsV4_SessionToken(t *testing.T) {
+	region, name := "universial", "query_api"
+	key := &Key{
+		AccessKey:    "spiderman",
+		SecretKey:    "@C*u0NrTxs@Y89m#",
+		SessionToken: "FQoGZXIvYXdzEXAMPLE",
+	}
+	keys := map[string]string{key.AccessKey: key.SecretKey}
+	validator := func(_ context.Context, accessKey, token string) error {
+		if token != key.SessionToken {
+			return errors.New("invalid token")
+		}
+		return nil
+	}
+
+	req := httptestRequest(t, "http://localhost:9527/app")
+	_, err := SignRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+	assert.Equal(t, key.SessionToken, req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "x-amz-security-token")
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4KeyMaps(req, keys, region, name, WithSessionTokenValidator(validator))
+	assert.NoError(t, err)
+
+	req = httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4UseQueryString(req, key, region, name)
+	assert.NoError(t, err)
+	assert.Equal(t, key.SessionToken, req.URL.Query().Get("X-Amz-Security-Token"))
+	_, _, err = CheckRequestWithAwsV4KeyMaps(req, keys, region, name, WithSessionTokenValidator(validator))
+	assert.NoError(t, err)
+
+	// signed without token
+	req = httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4(req, &Key{AccessKey: key.AccessKey, SecretKey: key.SecretKey}, region, name)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.Error(t, err)
+	_, _, err = CheckRequestWithAwsV4KeyMaps(req, keys, region, name, WithSessionTokenValidator(validator))
+	assert.Error(t, err)
+
+	// a wrong token of a known key fails like an unknown key until the signature matches
+	forged := &Key{AccessKey: key.AccessKey, SecretKey: "guessed", SessionToken: "guessed"}
+	req = httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4(req, forged, region, name)
+	assert.NoError(t, err)
+	_, _, known := CheckRequestWithAwsV4(req, key, region, name)
+	assert.ErrorIs(t, known, ErrSignatureMismatch)
+	assert.NotErrorIs(t, known, ErrInvalidSecurityToken)
+	_, _, err = CheckRequestWithAwsV4KeyMaps(req, keys, region, name, WithSessionTokenValidator(validator))
+	assert.NotErrorIs(t, err, ErrInvalidSecurityToken)
+	forged.AccessKey = "nobody"
+	req = httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4(req, forged, region, name)
+	assert.NoError(t, err)
+	_, _, unknown := CheckRequestWithAwsV4KeyMaps(req, keys, region, name)
+	assert.ErrorIs(t, unknown, ErrUnknownAccessKey)
+	assert.Equal(t, unknown.Error(), known.Error())
+}
+
+func TestCheckRequestWithAwsV4_Errors(t *testing.T) {
+	region, name := "universial", "query_api"
+	key := &Key{
+		AccessKey: "spiderman",
+		SecretKey: "@C*u0NrTxs@Y89m#",
+	}
+	keys := map[string]string{key.AccessKey: key.SecretKey}
+	signed := func() *http.Request {
+		req := httptestRequest(t, "http://localhost:9527/app")
+		_, err := SignRequestWithAwsV4(req, key, region, name)
+		assert.NoError(t, err)
+		return req
+	}
+
+	req := httptestRequest(t, "http://localhost:9527/app")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=spiderman")
+	_, _, err := CheckRequestWithAwsV4(req, key, region, name)
+	assert.ErrorIs(t, err, ErrMalformedAuthorization)
+
+	req = signed()
+	req.Header.Set("Authorization", strings.Replace(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256", "AWS4-HMAC-SHA1", 1))
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+
+	_, _, err = CheckRequestWithAwsV4KeyMaps(signed(), map[string]string{}, region, name)
+	assert.ErrorIs(t, err, ErrUnknownAccessKey)
+
+	_, _, err = CheckRequestWithAwsV4KeyMaps(signed(), keys, "other", name)
+	assert.ErrorIs(t, err, ErrScopeMismatch)
+
+	req = signed()
+	req.Header.Del("X-Amz-Date")
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.ErrorIs(t, err, ErrInvalidDate)
+
+	later := func() time.Time { return time.Now().Add(time.Hour) }
+	_, _, err = CheckRequestWithAwsV4(signed(), key, region, name, WithClock(later))
+	assert.ErrorIs(t, err, ErrRequestTimeSkewed)
+
+	req = httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4UseQueryString(req, key, region, name, WithExpires(time.Minute))
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithClock(later))
+	assert.ErrorIs(t, err, ErrExpired)
+
+	req = signed()
+	req.Header.Set("X-Amz-Date", req.Header.Get("X-Amz-Date")[:9]+"000000Z")
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithMaxSkew(24*time.Hour))
+	assert.ErrorIs(t, err, ErrSignatureMismatch)
+
+	// a valid signature spelled in upper case would get past the replay store
+	req = signed()
+	a, err := NewAuthorization(req)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", strings.Replace(req.Header.Get("Authorization"), a.Signature, strings.ToUpper(a.Signature), 1))
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.ErrorIs(t, err, ErrSignatureMismatch)
+}
+
+func TestCheckRequestWithAwsV4Disclosure(t *testing.T) {
+	key := &Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	region, name := "cn-shenzhen", "s3"
+	mismatched := func() *http.Request {
+		req := httptestRequest(t, "http://localhost:9527/app")
+		_, err := SignRequestWithAwsV4(req, key, region, name)
+		assert.NoError(t, err)
+		req.URL.Path = "/other"
+		return req
+	}
+	expected := func(req *http.Request) string {
+		_, sp, err := CheckRequestWithAwsV4(req, key, region, name, WithDisclosure(DisclosureDebug))
+		assert.ErrorIs(t, err, ErrSignatureMismatch)
+		return hex.EncodeToString(sp.AllSHA256)
+	}
+
+	req := mismatched()
+	signature := expected(mismatched())
+	_, sp, err := CheckRequestWithAwsV4(req, key, region, name)
+	assert.ErrorIs(t, err, ErrSignatureMismatch)
+	assert.Nil(t, sp)
+	assert.Equal(t, ErrSignatureMismatch.Error(), err.Error())
+
+	_, sp, err = CheckRequestWithAwsV4(mismatched(), key, region, name, WithDisclosure(DisclosureCanonical))
+	assert.ErrorIs(t, err, ErrSignatureMismatch)
+	assert.Nil(t, sp)
+	assert.Contains(t, err.Error(), "/other")
+	assert.Contains(t, err.Error(), aws4HmacSha256Algorithm)
+	assert.NotContains(t, err.Error(), signature)
+	assert.NotContains(t, err.Error(), key.SecretKey)
+
+	var mismatch *SignatureMismatchError
+	assert.True(t, errors.As(err, &mismatch))
+	assert.Nil(t, mismatch.Process)
+}
+
+func TestCheckRequestWithAwsV4UnknownKey(t *testing.T) {
+	region, name := "cn-shenzhen", "s3"
+	valid := &KeyInfo{Key: Key{AccessKey: "some_key_id", SecretKey: "some_secret"}}
+	disabled := &KeyInfo{Key: Key{AccessKey: "disabled_key_id", SecretKey: "some_secret"}, Disabled: true}
+	expired := &KeyInfo{Key: Key{AccessKey: "expired_key_id", SecretKey: "some_secret", Expires: time.Now().Add(-time.Hour)}}
+	store := NewMemoryKeyStore(valid, disabled, expired)
+
+	signed := func(accessKey, secretKey string) *http.Request {
+		req := httptestRequest(t, "http://localhost:9527/app")
+		_, err := SignRequestWithAwsV4(req, &Key{AccessKey: accessKey, SecretKey: secretKey}, region, name)
+		assert.NoError(t, err)
+		return req
+	}
+
+	for _, mode := range []DisclosureMode{DisclosureNone, DisclosureCanonical} {
+		_, _, badSignature := CheckRequestWithAwsV4KeyStore(signed("some_key_id", "other_secret"), store, region, name, WithDisclosure(mode))
+		assert.ErrorIs(t, badSignature, ErrSignatureMismatch)
+		assert.NotErrorIs(t, badSignature, ErrUnknownAccessKey)
+
+		for _, accessKey := range []string{"some_key_id_1", "disabled_key_id", "expired_key_id"} {
+			_, sp, err := CheckRequestWithAwsV4KeyStore(signed(accessKey, "some_secret"), store, region, name, WithDisclosure(mode))
+			assert.ErrorIs(t, err, ErrUnknownAccessKey)
+			assert.ErrorIs(t, err, ErrSignatureMismatch)
+			assert.Nil(t, sp)
+			// the client can not tell an unknown key from a bad signature
+			assert.Equal(t, badSignature.Error(), err.Error())
+		}
+	}
+
+	// a signing key is derived for an unknown key too
+	cache := NewSigningKeyCache(10)
+	_, _, err := CheckRequestWithAwsV4KeyStore(signed("some_key_id_1", "some_secret"), store, region, name, WithSigningKeyCache(cache))
+	assert.ErrorIs(t, err, ErrUnknownAccessKey)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestCheckRequestWithAwsV4UnknownKeyTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing")
+	}
+	region, name := "cn-shenzhen", "s3"
+	keys := KeyMap{"some_key_id": "some_secret"}
+	noCache := WithSigningKeyCache(NewSigningKeyCache(0))
+
+	request := func(accessKey, secretKey string) *http.Request {
+		req := httptestRequest(t, "http://localhost:9527/app")
+		_, err := SignRequestWithAwsV4(req, &Key{AccessKey: accessKey, SecretKey: secretKey}, region, name)
+		assert.NoError(t, err)
+		return req
+	}
+	measure := func(req *http.Request) time.Duration {
+		start := time.Now()
+		for i := 0; i < 200; i++ {
+			_, _, err := CheckRequestWithAwsV4KeyStore(req, keys, region, name, noCache)
+			assert.ErrorIs(t, err, ErrSignatureMismatch)
+		}
+		return time.Since(start)
+	}
+
+	// the fastest of interleaved rounds, to keep noise out
+	unknownReq, badReq := request("some_key_id_1", "some_secret"), request("some_key_id", "other_secret")
+	var unknownKey, badSignature time.Duration
+	for round := 0; round < 5; round++ {
+		if d := measure(unknownReq); round == 0 || d < unknownKey {
+			unknownKey = d
+		}
+		if d := measure(badReq); round == 0 || d < badSignature {
+			badSignature = d
+		}
+	}
+	// loose bounds, an early return for unknown keys is several times faster
+	assert.Less(t, unknownKey, 3*badSignature)
+	assert.Less(t, badSignature, 3*unknownKey)
+}
+
+func TestCheckRequestWithAwsV4Logger(t *testing.T) {
+	region, name := "cn-shenzhen", "s3"
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	req := httptestRequest(t, "http://localhost:9527/app")
+	req.RemoteAddr = "192.0.2.7:1234"
+	_, err := SignRequestWithAwsV4(req, &Key{AccessKey: "some_key_id", SecretKey: "some_secret"}, region, name)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4KeyMaps(req, map[string]string{}, region, name, WithLogger(logger))
+	assert.ErrorIs(t, err, ErrUnknownAccessKey)
+
+	output := buf.String()
+	assert.Contains(t, output, "access_key=some_key_id")
+	assert.Contains(t, output, "outcome=deny")
+	assert.Contains(t, output, `reason="unknown access key"`)
+	assert.Contains(t, output, "client_ip=192.0.2.7")
+	a, _ := NewAuthorization(req)
+	assert.NotContains(t, output, a.Signature)
+}
+
+func TestCheckRequestWithAwsV4RequiredSignedHeaders(t *testing.T) {
+	key := &Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	region, name := "us-east-1", "iam"
+	signed := func() *http.Request {
+		req := httptestRequest(t, "http://localhost:9527/app")
+		req.Header.Set("Content-Type", "application/json")
+		_, err := SignRequestWithAwsV4(req, key, region, name)
+		assert.NoError(t, err)
+		return req
+	}
+	without := func(header string) *http.Request {
+		req := signed()
+		auth := req.Header.Get(headKeyAuthorization)
+		req.Header.Set(headKeyAuthorization, strings.Replace(auth, header+";", "", 1))
+		return req
+	}
+
+	_, _, err := CheckRequestWithAwsV4(signed(), key, region, name)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(without("host"), key, region, name)
+	assert.ErrorIs(t, err, ErrMalformedAuthorization)
+	assert.ErrorContains(t, err, "host must be signed")
+
+	required := WithRequiredSignedHeaders(append(DefaultRequiredSignedHeaders(), "Content-Type")...)
+	_, _, err = CheckRequestWithAwsV4(signed(), key, region, name, required)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(without("content-type"), key, region, name, required)
+	assert.ErrorContains(t, err, "content-type must be signed")
+
+	// x-amz-date may be left out when the request is dated by a signed date header,
+	// the request passes the required headers and only fails as it was signed with x-amz-date
+	req := httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+	req.Header.Set(headKeyAuthorization, strings.Replace(req.Header.Get(headKeyAuthorization), "x-amz-date", "date", 1))
+	req.Header.Set(headKeyData, req.Header.Get(headKeyXAmzDate))
+	req.Header.Del(headKeyXAmzDate)
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.ErrorIs(t, err, ErrSignatureMismatch)
+
+	req = httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4UseQueryString(req, key, region, name)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.NoError(t, err)
+}
+
+func TestCheckRequestWithAwsV4_EmptySecretKey(t *testing.T) {
+	region, name := "us-east-1", "iam"
+	signer := &Key{AccessKey: "v4a_key_id", SecretKey: "some_secret"}
+	public, err := signer.ECDSAPublicKey()
+	assert.NoError(t, err)
+	store := NewMemoryKeyStore(&KeyInfo{Key: Key{AccessKey: "v4a_key_id", PublicKey: public}})
+
+	_, err = SignRequestWithAwsV4(httptestRequest(t, "http://localhost:9527/app"), &Key{AccessKey: "v4a_key_id"}, region, name)
+	assert.Error(t, err)
+
+	// signed with a key derived from an empty secret key, as anyone can
+	req := httptestRequest(t, "http://localhost:9527/app")
+	now := time.Now().UTC()
+	req.Header.Set(headKeyXAmzDate, now.Format(iSO8601BasicFormat))
+	c := &canonical{signHeader: defaultSignedHeader}
+	sp := &SignProcess{Key: (&Key{}).Sign(now, region, name)}
+	assert.NoError(t, writeStringToSign(now, req, nil, sp, c, false, region, name))
+	var signedHeaders bytes.Buffer
+	writeHeaderList(req, nil, c, &signedHeaders, false)
+	req.Header.Set(headKeyAuthorization, aws4HmacSha256Algorithm+" Credential=v4a_key_id/"+creds(now, region, name)+
+		", SignedHeaders="+signedHeaders.String()+", Signature="+hex.EncodeToString(sp.AllSHA256))
+
+	_, _, err = CheckRequestWithAwsV4KeyStore(req, store, region, name)
+	assert.ErrorIs(t, err, ErrUnknownAccessKey)
+	assert.Equal(t, ErrSignatureMismatch.Error(), err.Error())
+	_, _, err = CheckRequestWithAwsV4(req, &Key{AccessKey: "v4a_key_id"}, region, name)
+	assert.ErrorIs(t, err, ErrUnknownAccessKey)
+
+	// the public key still checks AWS4-ECDSA-P256-SHA256
+	req = httptestRequest(t, "http://localhost:9527/app")
+	_, err = SignRequestWithAwsV4(req, signer, region, name, WithSigV4a())
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4KeyStore(req, store, region, name)
+	assert.NoError(t, err)
+}
@@ -4,13 +4,14 @@ package v4
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -25,6 +26,12 @@ var lf = []byte{'\n'}
 type Key struct {
 	AccessKey string
 	SecretKey string
+	// SessionToken is sent as X-Amz-Security-Token for temporary credentials
+	SessionToken string
+	// Expires is when temporary credentials expire, zero for never
+	Expires time.Time
+	// PublicKey verifies Signature Version 4A without SecretKey, see ECDSAPublicKey
+	PublicKey *ecdsa.PublicKey
 }
 
 /*
@@ -40,104 +47,228 @@ func (k *Key) Sign(t time.Time, region, name string) []byte {
 	return h
 }
 
+// canonical controls how the canonical request is built
+type canonical struct {
+	// algorithm is aws4HmacSha256Algorithm if empty
+	algorithm string
+	// payloadHash is used instead of hashing the body if not empty
+	payloadHash string
+	// signHeader selects the lower case headers a client signs besides host, all if nil
+	signHeader func(header string) bool
+	// s3Path keeps the path as it is and encodes it once
+	s3Path bool
+	// headerMode canonicalizes header values
+	headerMode HeaderMode
+}
+
+// HeaderMode is how header values are canonicalized
+type HeaderMode int
+
+const (
+	// HeaderModeSpec keeps the order of the values, trims them and collapses sequential spaces, as AWS SDKs do
+	HeaderModeSpec HeaderMode = iota
+	// HeaderModeLegacy sorts the values and keeps their spaces, as this package did before
+	HeaderModeLegacy
+)
+
+// signs reports whether the lower case header is part of the canonical request
+func (c *canonical) signs(au *Authorization, header string, isServer bool) bool {
+	if isServer {
+		return au.containsSignedHeader(header)
+	}
+	return header == headKeyHost || c.signHeader == nil || c.signHeader(header)
+}
+
+func (c *canonical) isV4a() bool {
+	return c.algorithm == aws4EcdsaP256Sha256Algorithm
+}
+
+func (c *canonical) algorithmName() string {
+	if c.isV4a() {
+		return aws4EcdsaP256Sha256Algorithm
+	}
+	return aws4HmacSha256Algorithm
+}
+
+func (c *canonical) scope(t time.Time, region, name string) string {
+	if c.isV4a() {
+		return credsV4a(t, name)
+	}
+	return creds(t, region, name)
+}
+
 func writeStringToSign(
 	t time.Time,
 	r *http.Request,
 	a *Authorization,
 	sp *SignProcess,
+	c *canonical,
 	isServer bool,
-	region, name string) {
-	lastData := bytes.NewBufferString(aws4HmacSha256Algorithm)
+	region, name string) error {
+	lastData := bytes.NewBufferString(c.algorithmName())
 	lastData.Write(lf)
 
 	lastData.Write([]byte(t.Format(iSO8601BasicFormat)))
 	lastData.Write(lf)
 
-	lastData.Write([]byte(creds(t, region, name)))
+	lastData.Write([]byte(c.scope(t, region, name)))
 	lastData.Write(lf)
 
-	writeRequest(r, a, sp, isServer)
+	if err := writeRequest(r, a, sp, c, isServer); err != nil {
+		return err
+	}
 	lastData.WriteString(hex.EncodeToString(sp.RequestSHA256))
 	// fmt.Fprintf(lastData, "%x", sp.RequestSHA256)
 
 	sp.All = lastData.Bytes()
-	sp.AllSHA256 = ghmac(sp.Key, sp.All)
+	// the ecdsa signature of Signature Version 4A is made by the caller
+	if !c.isV4a() {
+		sp.AllSHA256 = ghmac(sp.Key, sp.All)
+	}
+	return nil
 }
 
-func writeRequest(r *http.Request, a *Authorization, sp *SignProcess, isServer bool) {
+func writeRequest(r *http.Request, a *Authorization, sp *SignProcess, c *canonical, isServer bool) error {
 	requestData := bytes.NewBufferString("")
-	r.Header.Set(headKeyHost, r.Host)
+	r.Header.Set(headKeyHost, requestHost(r))
 
 	requestData.Write([]byte(r.Method))
 	requestData.Write(lf)
 
-	writeURI(r, requestData)
+	writeURI(r, c, requestData)
 	requestData.Write(lf)
 
 	writeQuery(r, requestData)
 	requestData.Write(lf)
 
-	writeHeader(r, a, requestData, isServer)
+	writeHeader(r, a, c, requestData, isServer)
 	requestData.Write(lf)
 	requestData.Write(lf)
 
-	writeHeaderList(r, a, requestData, isServer)
+	writeHeaderList(r, a, c, requestData, isServer)
 	requestData.Write(lf)
 
-	writeBody(r, requestData, sp)
+	if c.payloadHash != "" {
+		_, _ = requestData.WriteString(c.payloadHash)
+	} else if err := writeBody(r, requestData, sp); err != nil {
+		return err
+	}
 
 	sp.Request = requestData.Bytes()
 	sp.RequestSHA256 = gsha256(sp.Request)
+	return nil
+}
+
+// requestHost is the Host header to be sent, client requests may only have it in the url
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.URL.Host
 }
 
-func writeURI(r *http.Request, requestData io.Writer) {
-	path := r.URL.RequestURI()
-	if r.URL.RawQuery != "" {
-		path = path[:len(path)-len(r.URL.RawQuery)-1]
+/*
+writeURI writes the path with every segment URI-encoded twice, after removing empty, "." and ".." segments.
+With s3Path it is encoded once and not normalized.
+https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+*/
+func writeURI(r *http.Request, c *canonical, requestData io.Writer) {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	segments := strings.Split(path, "/")
+	if !c.s3Path {
+		segments = normalizePath(segments)
+	}
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		segment = uriEncode(segment)
+		if !c.s3Path {
+			segment = uriEncode(segment)
+		}
+		segments[i] = segment
+	}
+	_, _ = io.WriteString(requestData, strings.Join(segments, "/"))
+}
+
+// normalizePath removes empty, "." and ".." segments, keeping the leading and trailing slash
+func normalizePath(segments []string) []string {
+	result := []string{""}
+	for _, segment := range segments[1:] {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(result) > 1 {
+				result = result[:len(result)-1]
+			}
+		default:
+			result = append(result, segment)
+		}
+	}
+	if last := segments[len(segments)-1]; last == "" || last == "." || last == ".." {
+		result = append(result, "")
 	}
-	slash := strings.HasSuffix(path, "/")
-	path = filepath.Clean(path)
-	if path != "/" && slash {
-		path += "/"
+	if len(result) == 1 {
+		result = append(result, "")
 	}
-	_, _ = requestData.Write([]byte(path))
+	return result
 }
 
+// writeQuery writes the URI-encoded parameters sorted by name then value, X-Amz-Signature left out
 func writeQuery(r *http.Request, requestData io.Writer) {
-	var a []string
+	type param struct{ key, value string }
+	var params []param
 	for k, vs := range r.URL.Query() {
-		k = url.QueryEscape(k)
 		if k == queryKeySignature {
 			continue
 		}
 		for _, v := range vs {
-			if v == "" {
-				a = append(a, k)
-			} else {
-				v = url.QueryEscape(v)
-				a = append(a, k+"="+v)
-			}
+			params = append(params, param{key: uriEncode(k), value: uriEncode(v)})
 		}
 	}
-	sort.Strings(a)
-	for i, s := range a {
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].key != params[j].key {
+			return params[i].key < params[j].key
+		}
+		return params[i].value < params[j].value
+	})
+	for i, p := range params {
 		if i > 0 {
-			_, _ = requestData.Write([]byte{'&'})
+			_, _ = io.WriteString(requestData, "&")
+		}
+		_, _ = io.WriteString(requestData, p.key+"="+p.value)
+	}
+}
+
+// uriEncode encodes every byte but the unreserved characters of RFC 3986, / included
+func uriEncode(s string) string {
+	const hexUpper = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexUpper[ch>>4])
+			b.WriteByte(hexUpper[ch&15])
 		}
-		_, _ = requestData.Write([]byte(s))
 	}
+	return b.String()
 }
 
-func writeHeader(r *http.Request, au *Authorization, requestData *bytes.Buffer, isServer bool) {
+func writeHeader(r *http.Request, au *Authorization, c *canonical, requestData *bytes.Buffer, isServer bool) {
 	a := make([]string, 0)
 	for k, v := range r.Header {
-		if isServer {
-			if !au.containsSignedHeader(strings.ToLower(k)) {
-				continue
-			}
+		if !c.signs(au, strings.ToLower(k), isServer) {
+			continue
 		}
-		sort.Strings(v)
-		a = append(a, strings.ToLower(k)+":"+strings.Join(v, ","))
+		a = append(a, strings.ToLower(k)+":"+c.headerValue(v))
 	}
 	sort.Strings(a)
 	for i, s := range a {
@@ -148,13 +279,41 @@ func writeHeader(r *http.Request, au *Authorization, requestData *bytes.Buffer,
 	}
 }
 
-func writeHeaderList(r *http.Request, au *Authorization, requestData io.Writer, isServer bool) {
+// headerValue joins the values of a header
+func (c *canonical) headerValue(values []string) string {
+	if c.headerMode == HeaderModeLegacy {
+		values = append([]string(nil), values...)
+		sort.Strings(values)
+		return strings.Join(values, ",")
+	}
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = collapseSpaces(v)
+	}
+	return strings.Join(trimmed, ",")
+}
+
+// collapseSpaces trims the spaces around v and turns sequential spaces into one, tabs are kept as the SDKs do
+func collapseSpaces(v string) string {
+	v = strings.Trim(v, " ")
+	if !strings.Contains(v, "  ") {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == ' ' && i > 0 && v[i-1] == ' ' {
+			continue
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+func writeHeaderList(r *http.Request, au *Authorization, c *canonical, requestData io.Writer, isServer bool) {
 	a := make([]string, 0)
 	for k := range r.Header {
-		if isServer {
-			if !au.containsSignedHeader(strings.ToLower(k)) {
-				continue
-			}
+		if !c.signs(au, strings.ToLower(k), isServer) {
+			continue
 		}
 		a = append(a, strings.ToLower(k))
 	}
@@ -167,7 +326,16 @@ func writeHeaderList(r *http.Request, au *Authorization, requestData io.Writer,
 	}
 }
 
-func writeBody(r *http.Request, requestData io.StringWriter, sp *SignProcess) {
+func writeBody(r *http.Request, requestData io.StringWriter, sp *SignProcess) error {
+	if err := hashBody(r, sp); err != nil {
+		return err
+	}
+	_, _ = requestData.WriteString(hex.EncodeToString(sp.BodySHA256))
+	return nil
+}
+
+// hashBody reads the whole body and puts it back, it fails when the body can not be read
+func hashBody(r *http.Request, sp *SignProcess) error {
 	var b []byte
 	// If the payload is empty, use the empty string as the input to the SHA256 function
 	// http://docs.amazonwebservices.com/general/latest/gr/sigv4-create-canonical-request.html
@@ -177,14 +345,27 @@ func writeBody(r *http.Request, requestData io.StringWriter, sp *SignProcess) {
 		var err error
 		b, err = io.ReadAll(r.Body)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("read body: %w", err)
 		}
 		r.Body = io.NopCloser(bytes.NewBuffer(b))
 	}
 	sp.Body = b
 
 	sp.BodySHA256 = gsha256(b)
-	_, _ = requestData.WriteString(hex.EncodeToString(sp.BodySHA256))
+	return nil
+}
+
+// isSHA256Hex reports whether s is a lower case hex encoded sha256 digest
+func isSHA256Hex(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
 }
 
 func creds(t time.Time, region, name string) string {
@@ -0,0 +1,134 @@
+package v4
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteURI(t *testing.T) {
+	cases := []struct {
+		url, path, s3Path string
+	}{
+		{"http://localhost", "/", "/"},
+		{"http://localhost/", "/", "/"},
+		{"http://localhost/documents and settings/", "/documents%2520and%2520settings/", "/documents%20and%20settings/"},
+		{"http://localhost/a/./b/../c//d/", "/a/c/d/", "/a/./b/../c//d/"},
+		{"http://localhost/example/..", "/", "/example/.."},
+		{"http://localhost/~user/a-b_c.d", "/~user/a-b_c.d", "/~user/a-b_c.d"},
+		{"http://localhost/%E1%88%B4", "/%25E1%2588%25B4", "/%E1%88%B4"},
+		{"http://localhost/a%2Fb/c+d", "/a%252Fb/c%252Bd", "/a%2Fb/c%2Bd"},
+	}
+	for _, tc := range cases {
+		req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+		assert.NoError(t, err)
+
+		var buf bytes.Buffer
+		writeURI(req, &canonical{}, &buf)
+		assert.Equal(t, tc.path, buf.String(), tc.url)
+
+		buf.Reset()
+		writeURI(req, &canonical{s3Path: true}, &buf)
+		assert.Equal(t, tc.s3Path, buf.String(), tc.url)
+	}
+}
+
+func TestWriteQuery(t *testing.T) {
+	cases := []struct {
+		query, canonical string
+	}{
+		{"", ""},
+		{"Param2=value2&Param1=value1", "Param1=value1&Param2=value2"},
+		{"a=2&a=1&a-b=1&c", "a=1&a=2&a-b=1&c="},
+		{"d=x%20y&e=~*&f=x+y&g=%E1%88%B4", "d=x%20y&e=~%2A&f=x%20y&g=%E1%88%B4"},
+		{"X-Amz-Signature=abc&X-Amz-Date=20150830T123600Z", "X-Amz-Date=20150830T123600Z"},
+	}
+	for _, tc := range cases {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost/?"+tc.query, nil)
+		assert.NoError(t, err)
+
+		var buf bytes.Buffer
+		writeQuery(req, &buf)
+		assert.Equal(t, tc.canonical, buf.String(), tc.query)
+	}
+}
+
+func TestS3PathEncoding(t *testing.T) {
+	key := &Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	region, name := "us-east-1", "s3"
+	req := httptestRequest(t, "http://localhost:9527/bucket/a//b c.txt")
+	_, err := SignRequestWithAwsV4(req, key, region, name, WithS3PathEncoding())
+	assert.NoError(t, err)
+
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name, WithS3PathEncodingCheck())
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+	assert.ErrorIs(t, err, ErrSignatureMismatch)
+}
+
+func TestHeaderMode(t *testing.T) {
+	values := []string{"  b  c ", "a"}
+	assert.Equal(t, "b c,a", (&canonical{}).headerValue(values))
+	assert.Equal(t, "  b  c ,a", (&canonical{headerMode: HeaderModeLegacy}).headerValue(values))
+	assert.Equal(t, []string{"  b  c ", "a"}, values)
+	assert.Equal(t, "a\tb", collapseSpaces(" a\tb "))
+
+	key := &Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	region, name := "us-east-1", "iam"
+	signed := func(opts ...SignOption) *http.Request {
+		req := httptestRequest(t, "http://localhost:9527/app")
+		req.Header.Add("X-Amz-Meta-List", "z   y")
+		req.Header.Add("X-Amz-Meta-List", "x")
+		_, err := SignRequestWithAwsV4(req, key, region, name, opts...)
+		assert.NoError(t, err)
+		return req
+	}
+
+	_, _, err := CheckRequestWithAwsV4(signed(), key, region, name)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(signed(WithHeaderMode(HeaderModeLegacy)), key, region, name)
+	assert.ErrorIs(t, err, ErrSignatureMismatch)
+
+	// during a migration both are accepted
+	migrating := WithHeaderModes(HeaderModeSpec, HeaderModeLegacy)
+	_, _, err = CheckRequestWithAwsV4(signed(), key, region, name, migrating)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(signed(WithHeaderMode(HeaderModeLegacy)), key, region, name, migrating)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(signed(WithHeaderMode(HeaderModeLegacy)), key, region, name, WithHeaderModes(HeaderModeLegacy))
+	assert.NoError(t, err)
+}
+
+func TestSignedHeaders(t *testing.T) {
+	key := &Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	region, name := "us-east-1", "iam"
+	signedHeaders := func(opts ...SignOption) []string {
+		req := httptestRequest(t, "http://localhost:9527/app")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-MD5", "1B2M2Y8AsgTpgAmY7PhCfg==")
+		req.Header.Set("User-Agent", "some-agent")
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Connection", "keep-alive")
+		req.Header.Set("X-Amz-Meta-Owner", "some_team")
+		req.Header.Set("X-Custom", "some_value")
+		_, err := SignRequestWithAwsV4(req, key, region, name, opts...)
+		assert.NoError(t, err)
+
+		// a proxy rewriting unsigned headers does not break the signature
+		req.Header.Set("Accept-Encoding", "identity")
+		req.Header.Set("Connection", "close")
+		_, _, err = CheckRequestWithAwsV4(req, key, region, name)
+		assert.NoError(t, err)
+
+		a, err := NewAuthorization(req)
+		assert.NoError(t, err)
+		return a.SignedHeaders
+	}
+
+	assert.Equal(t, []string{"content-md5", "content-type", "host", "x-amz-date", "x-amz-meta-owner"}, signedHeaders())
+	assert.Equal(t, []string{"host", "x-amz-date", "x-amz-meta-owner", "x-custom"}, signedHeaders(WithSignedHeaders("X-Custom")))
+	assert.Equal(t, []string{"content-md5", "content-type", "host", "user-agent", "x-amz-date", "x-amz-meta-owner", "x-custom"},
+		signedHeaders(WithUnsignedHeaders("Accept-Encoding")))
+}
@@ -0,0 +1,123 @@
+package v4
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+/*
+Signature Version 4A, the signing key is an ECDSA P-256 key derived from the secret key,
+the credential scope has no region, the regions are listed in X-Amz-Region-Set instead.
+https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html#derive-signing-key-sigv4a
+*/
+
+var nMinusTwoP256 = new(big.Int).Sub(elliptic.P256().Params().N, big.NewInt(2))
+
+/*
+ECDSAKey derive the private key for Signature Version 4A
+https://github.com/aws/aws-sdk-go-v2/blob/main/internal/v4a/credentials.go
+*/
+func (k *Key) ECDSAKey() (*ecdsa.PrivateKey, error) {
+	if k.SecretKey == "" {
+		return nil, fmt.Errorf("can not derive ecdsa key of %s without secret key", k.AccessKey)
+	}
+	inputKey := []byte("AWS4A" + k.SecretKey)
+	kdfContext := make([]byte, 0, len(k.AccessKey)+1)
+
+	d := new(big.Int)
+	for counter := 1; ; counter++ {
+		if counter > 0xFF {
+			return nil, fmt.Errorf("can not derive ecdsa key of %s: exhausted counter", k.AccessKey)
+		}
+		kdfContext = append(append(kdfContext[:0], k.AccessKey...), byte(counter))
+		candidate := hmacKeyDerivation(inputKey, []byte(aws4EcdsaP256Sha256Algorithm), kdfContext, 256)
+		d.SetBytes(candidate)
+		if d.Cmp(nMinusTwoP256) < 0 {
+			break
+		}
+	}
+	d.Add(d, big.NewInt(1))
+
+	priv, err := ecdh.P256().NewPrivateKey(d.FillBytes(make([]byte, 32)))
+	if err != nil {
+		return nil, err
+	}
+	// uncompressed point: 0x04 || X || Y
+	point := priv.PublicKey().Bytes()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(point[1:33]),
+			Y:     new(big.Int).SetBytes(point[33:]),
+		},
+		D: d,
+	}, nil
+}
+
+// ECDSAPublicKey returns PublicKey, or derives it from the secret key
+func (k *Key) ECDSAPublicKey() (*ecdsa.PublicKey, error) {
+	if k.PublicKey != nil {
+		return k.PublicKey, nil
+	}
+	priv, err := k.ECDSAKey()
+	if err != nil {
+		return nil, err
+	}
+	return &priv.PublicKey, nil
+}
+
+// hmacKeyDerivation is the NIST SP 800-108 KDF in counter mode with HMAC-SHA256
+func hmacKeyDerivation(key, label, context []byte, bitLen int) []byte {
+	fixedInput := make([]byte, 0, len(label)+1+len(context)+4)
+	fixedInput = append(fixedInput, label...)
+	fixedInput = append(fixedInput, 0x00)
+	fixedInput = append(fixedInput, context...)
+	fixedInput = binary.BigEndian.AppendUint32(fixedInput, uint32(bitLen))
+
+	h := hmac.New(sha256.New, key)
+	n := (bitLen/8 + sha256.Size - 1) / sha256.Size
+	output := make([]byte, 0, n*sha256.Size)
+	for i := 1; i <= n; i++ {
+		h.Reset()
+		_ = binary.Write(h, binary.BigEndian, uint32(i))
+		_, _ = h.Write(fixedInput)
+		output = h.Sum(output)
+	}
+	return output[:bitLen/8]
+}
+
+func credsV4a(t time.Time, name string) string {
+	return t.Format(iSO8601BasicFormatShort) + "/" + name + "/aws4_request"
+}
+
+func splitRegionSet(set string) []string {
+	if set == "" {
+		return nil
+	}
+	regions := strings.Split(set, ",")
+	for i := range regions {
+		regions[i] = strings.TrimSpace(regions[i])
+	}
+	return regions
+}
+
+// containsRegion reports whether the region set covers region, * matches any region
+func containsRegion(regionSet []string, region string) bool {
+	for _, item := range regionSet {
+		if item == "*" || item == region {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(item, "*"); ok && strings.HasPrefix(region, prefix) {
+			return true
+		}
+	}
+	return false
+}
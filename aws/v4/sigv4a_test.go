@@ -0,0 +1,56 @@
+package v4
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// https://github.com/aws/aws-sdk-go-v2/blob/main/internal/v4a/credentials_test.go
+func TestKey_ECDSAKey(t *testing.T) {
+	key := &Key{
+		AccessKey: "AKISORANDOMAASORANDOM",
+		SecretKey: "q+jcrXGc+0zWN6uzclKVhvMmUsIfRPa4rlRandom",
+	}
+	priv, err := key.ECDSAKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "15D242CEEBF8D8169FD6A8B5A746C41140414C3B07579038DA06AF89190FFFCB", fmt.Sprintf("%X", priv.X))
+	assert.Equal(t, "515242CEDD82E94799482E4C0514B505AFCCF2C0C98D6A553BF539F424C5EC0", fmt.Sprintf("%X", priv.Y))
+}
+
+func TestCheckRequestWithAwsV4_SigV4a(t *testing.T) {
+	region, name := "us-east-1", "query_api"
+	key := &Key{
+		AccessKey: "spiderman",
+		SecretKey: "@C*u0NrTxs@Y89m#",
+	}
+	pub, err := key.ECDSAPublicKey()
+	assert.NoError(t, err)
+	verifyKey := &Key{AccessKey: key.AccessKey, PublicKey: pub}
+
+	req, err := http.NewRequest("POST", "http://localhost:9527/app", strings.NewReader(`{"id": 1}`))
+	assert.NoError(t, err)
+	_, err = SignRequestWithAwsV4(req, key, region, name, WithSigV4a("us-*"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-ECDSA-P256-SHA256 Credential=spiderman/"))
+
+	_, _, err = CheckRequestWithAwsV4(req, verifyKey, region, name)
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, verifyKey, "eu-west-1", name)
+	assert.Error(t, err)
+
+	other, err := (&Key{AccessKey: key.AccessKey, SecretKey: "other"}).ECDSAPublicKey()
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4(req, &Key{AccessKey: key.AccessKey, PublicKey: other}, region, name)
+	assert.Error(t, err)
+
+	req, err = http.NewRequest("GET", "http://localhost:9527/app", nil)
+	assert.NoError(t, err)
+	_, err = SignRequestWithAwsV4UseQueryString(req, key, region, name, WithSigV4a())
+	assert.NoError(t, err)
+	_, _, err = CheckRequestWithAwsV4KeyMaps(req, map[string]string{key.AccessKey: key.SecretKey}, region, name)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,70 @@
+package v4
+
+import (
+	"net/http"
+)
+
+// Transport is an http.RoundTripper which signs every request before Base sends it.
+// The request is cloned and its body taken from GetBody when possible,
+// so retries and redirects made by http.Client are signed again.
+type Transport struct {
+	// Base sends the signed request, http.DefaultTransport if nil
+	Base        http.RoundTripper
+	Credentials CredentialsProvider
+	Region      string
+	Name        string
+	// UseQueryString signs with SignRequestWithAwsV4UseQueryString instead of the authorization header
+	UseQueryString bool
+	Options        []SignOption
+}
+
+// NewTransport signs with the authorization header over http.DefaultTransport
+func NewTransport(provider CredentialsProvider, region, name string, opts ...SignOption) *Transport {
+	return &Transport{
+		Credentials: provider,
+		Region:      region,
+		Name:        name,
+		Options:     opts,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed := req.Clone(req.Context())
+	if req.GetBody != nil && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			closeBody(req)
+			return nil, err
+		}
+		closeBody(req)
+		signed.Body = body
+	}
+	// a request sent again must not keep the signature of the last attempt
+	signed.Header.Del(headKeyAuthorization)
+
+	var err error
+	if t.UseQueryString {
+		_, err = SignRequestWithAwsV4UseQueryString(signed, t.Credentials, t.Region, t.Name, t.Options...)
+	} else {
+		_, err = SignRequestWithAwsV4(signed, t.Credentials, t.Region, t.Name, t.Options...)
+	}
+	if err != nil {
+		closeBody(signed)
+		return nil, err
+	}
+	return t.base().RoundTrip(signed)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
@@ -0,0 +1,50 @@
+package v4
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	region, name := "universial", "query_api"
+	key := &Key{
+		AccessKey: "spiderman",
+		SecretKey: "@C*u0NrTxs@Y89m#",
+	}
+	bodyStr := `{"id": 1}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusTemporaryRedirect)
+			return
+		}
+		if _, _, err := CheckRequestWithAwsV4(r, key, region, name); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	for _, useQueryString := range []bool{false, true} {
+		transport := NewTransport(key, region, name)
+		transport.UseQueryString = useQueryString
+		client := &http.Client{Transport: transport}
+
+		req, err := http.NewRequest("POST", server.URL+"/old", strings.NewReader(bodyStr))
+		assert.NoError(t, err)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		assert.Equal(t, bodyStr, string(body))
+		assert.Empty(t, req.Header.Get("Authorization"))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -17,10 +18,18 @@ type Authorization struct {
 	AccessKeyID    string   `json:"access_key_id,omitempty"`
 	CredentialTime string   `json:"credential_time,omitempty"`
 	Region         string   `json:"region,omitempty"`
+	RegionSet      []string `json:"region_set,omitempty"` // X-Amz-Region-Set, only for AWS4-ECDSA-P256-SHA256
 	Name           string   `json:"name,omitempty"`
 	SignedHeaders  []string `json:"signedHeaders,omitempty"`
 	Signature      string   `json:"signature,omitempty"`
+	// Expires is X-Amz-Expires, only for query string
+	Expires time.Duration `json:"expires,omitempty"`
+	// Date is the request time, set by Check
+	Date time.Time `json:"date,omitempty"`
+	// Metadata is KeyInfo.Metadata of the key, set by CheckRequestWithAwsV4KeyStore once the request passes
+	Metadata map[string]string `json:"metadata,omitempty"`
 
+	byQuery              bool
 	initSignedHeadersMap bool
 	signedHeadersMap     map[string]bool
 }
@@ -31,18 +40,51 @@ https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
 https://docs.aws.amazon.com/zh_cn/general/latest/gr/sigv4_signing.html
 */
 func NewAuthorization(req *http.Request) (a *Authorization, err error) {
+	if len(req.Header.Values(headKeyAuthorization)) > 1 {
+		return nil, fmt.Errorf("%w: repeated %s header", ErrMalformedAuthorization, headKeyAuthorization)
+	}
 	content := req.Header.Get(headKeyAuthorization)
 	if len(content) > 0 {
-		return newAuthorizationByHeader(content)
+		query := req.URL.Query()
+		if query.Has(queryKeyAlgorithm) || query.Has(queryKeyCredential) || query.Has(queryKeySignature) {
+			return nil, fmt.Errorf("%w: credentials in both the %s header and the query are ambiguous", ErrMalformedAuthorization, headKeyAuthorization)
+		}
+		a, err = newAuthorizationByHeader(content)
+	} else {
+		a, err = newAuthorizationByQueryValues(req.URL.Query())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Algorithm == aws4EcdsaP256Sha256Algorithm {
+		regionSet := req.Header.Get(headKeyRegionSet)
+		if a.byQuery {
+			regionSet = req.URL.Query().Get(queryKeyRegionSet)
+		}
+		a.RegionSet = splitRegionSet(regionSet)
 	}
-	return newAuthorizationByQueryValues(req.URL.Query())
+	return
 }
 
-// DecodeCredential example: AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request
+/*
+DecodeCredential example: AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request
+for AWS4-ECDSA-P256-SHA256 there is no region: AKIDEXAMPLE/20150830/iam/aws4_request
+*/
 func (a *Authorization) DecodeCredential() (err error) {
 	credentialList := strings.Split(a.Credential, "/")
+	if a.Algorithm == aws4EcdsaP256Sha256Algorithm {
+		if len(credentialList) != 4 {
+			err = fmt.Errorf("%w: invalid Credential: %s", ErrMalformedAuthorization, a.Credential)
+			return
+		}
+		a.AccessKeyID = credentialList[0]
+		a.CredentialTime = credentialList[1]
+		a.Name = credentialList[2]
+		return
+	}
 	if len(credentialList) != 5 {
-		err = fmt.Errorf("invalid Credential: %s", a.Credential)
+		err = fmt.Errorf("%w: invalid Credential: %s", ErrMalformedAuthorization, a.Credential)
 		return
 	}
 	a.AccessKeyID = credentialList[0]
@@ -52,33 +94,101 @@ func (a *Authorization) DecodeCredential() (err error) {
 	return
 }
 
-func newAuthorizationByHeader(content string) (a *Authorization, err error) {
-	list := strings.Split(content, " ")
-	if len(list) != 4 {
-		err = fmt.Errorf("invalid authorization: %s", content)
-		return
-	}
-	a = &Authorization{
-		Algorithm: list[0],
+// AuthorizationParseError is a malformed authorization header, it is ErrMalformedAuthorization
+type AuthorizationParseError struct {
+	// Offset is the byte offset in the header where parsing failed
+	Offset int
+	Reason string
+}
+
+func (e *AuthorizationParseError) Error() string {
+	return fmt.Sprintf("%s: at offset %d: %s", ErrMalformedAuthorization, e.Offset, e.Reason)
+}
+
+// Unwrap makes errors.Is(err, ErrMalformedAuthorization) true
+func (e *AuthorizationParseError) Unwrap() error {
+	return ErrMalformedAuthorization
+}
+
+/*
+newAuthorizationByHeader parses
+AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=host;x-amz-date, Signature=...
+The components may come in any order, with or without whitespace around the commas.
+*/
+func newAuthorizationByHeader(content string) (*Authorization, error) {
+	parseError := func(offset int, format string, args ...interface{}) error {
+		return &AuthorizationParseError{Offset: offset, Reason: fmt.Sprintf(format, args...)}
 	}
+	isSpace := func(ch byte) bool { return ch == ' ' || ch == '\t' }
 
-	if a.Credential, err = getValueString("Credential=", list[1]); err != nil {
-		return nil, err
+	i := 0
+	for i < len(content) && isSpace(content[i]) {
+		i++
 	}
-	if err = a.DecodeCredential(); err != nil {
-		return nil, err
+	start := i
+	for i < len(content) && !isSpace(content[i]) {
+		i++
+	}
+	if i == start {
+		return nil, parseError(start, "missing algorithm")
 	}
+	a := &Authorization{Algorithm: content[start:i]}
 
-	var signedHeadersStr string
-	if signedHeadersStr, err = getValueString("SignedHeaders=", list[2]); err != nil {
-		return nil, err
+	values := make(map[string]string, 3)
+	for i < len(content) {
+		for i < len(content) && (isSpace(content[i]) || (content[i] == ',' && len(values) > 0)) {
+			i++
+		}
+		if i == len(content) {
+			break
+		}
+		start = i
+		for i < len(content) && content[i] != '=' && content[i] != ',' && !isSpace(content[i]) {
+			i++
+		}
+		name := content[start:i]
+		if i == len(content) || content[i] != '=' {
+			return nil, parseError(i, "expected '=' after %q", name)
+		}
+		switch name {
+		case "Credential", "SignedHeaders", "Signature":
+		default:
+			return nil, parseError(start, "unknown component %q", name)
+		}
+		if _, ok := values[name]; ok {
+			return nil, parseError(start, "repeated component %s", name)
+		}
+		i++
+		valueStart := i
+		for i < len(content) && content[i] != ',' && !isSpace(content[i]) {
+			i++
+		}
+		if i == valueStart {
+			return nil, parseError(valueStart, "empty %s", name)
+		}
+		values[name] = content[valueStart:i]
+
+		// only whitespace may come before the next comma
+		for i < len(content) && isSpace(content[i]) {
+			i++
+		}
+		if i < len(content) && content[i] != ',' {
+			return nil, parseError(i, "expected ',' after %s", name)
+		}
+	}
+	for _, name := range []string{"Credential", "SignedHeaders", "Signature"} {
+		if _, ok := values[name]; !ok {
+			return nil, parseError(len(content), "missing component %s", name)
+		}
 	}
-	a.SignedHeaders = strings.Split(signedHeadersStr, ";")
 
-	if a.Signature, err = getValueString("Signature=", list[3]); err != nil {
+	a.Credential = values["Credential"]
+	if err := a.DecodeCredential(); err != nil {
 		return nil, err
 	}
-	return
+	a.SignedHeaders = strings.Split(values["SignedHeaders"], ";")
+	a.Signature = values["Signature"]
+	return a, nil
 }
 
 func newAuthorizationByQueryValues(uValues url.Values) (a *Authorization, err error) {
@@ -93,6 +203,16 @@ func newAuthorizationByQueryValues(uValues url.Values) (a *Authorization, err er
 	}
 
 	a.SignedHeaders = strings.Split(uValues.Get(queryKeySignatureHeaders), ";")
+
+	a.byQuery = true
+	if expires := uValues.Get(queryKeyExpires); len(expires) > 0 {
+		var seconds int64
+		seconds, err = strconv.ParseInt(expires, 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("%w: invalid %s: %s", ErrMalformedAuthorization, queryKeyExpires, expires)
+		}
+		a.Expires = time.Duration(seconds) * time.Second
+	}
 	return
 }
 
@@ -106,9 +226,13 @@ Check details
 https://docs.aws.amazon.com/general/latest/gr/sigv4-date-handling.html
 https://docs.aws.amazon.com/zh_cn/general/latest/gr/sigv4-date-handling.html
 */
-func (a *Authorization) Check(req *http.Request, region, name string) (t time.Time, err error) {
-	if a.Algorithm != aws4HmacSha256Algorithm {
-		err = fmt.Errorf("invalid sign algorithm: %s", a.Algorithm)
+func (a *Authorization) Check(req *http.Request, region, name string, opts ...CheckOption) (t time.Time, err error) {
+	return a.check(req, region, name, newCheckOptions(opts))
+}
+
+func (a *Authorization) check(req *http.Request, region, name string, o *checkOptions) (t time.Time, err error) {
+	if a.Algorithm != aws4HmacSha256Algorithm && a.Algorithm != aws4EcdsaP256Sha256Algorithm {
+		err = fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, a.Algorithm)
 		return
 	}
 
@@ -120,46 +244,140 @@ func (a *Authorization) Check(req *http.Request, region, name string) (t time.Ti
 		dateStr = req.Header.Get(headKeyData)
 	}
 	if len(dateStr) == 0 {
-		err = fmt.Errorf("can not found date(header(%s,%s) and query(%s))", headKeyXAmzDate, headKeyData, queryKeyDate)
+		err = fmt.Errorf("%w: can not found date(header(%s,%s) and query(%s))", ErrInvalidDate, headKeyXAmzDate, headKeyData, queryKeyDate)
 		return
 	}
 	t, err = time.Parse(iSO8601BasicFormat, dateStr)
 	if err != nil {
-		err = fmt.Errorf("can not parse time(%s) in header(%s,%s) as format %s", dateStr, headKeyXAmzDate, headKeyData, iSO8601BasicFormat)
+		err = fmt.Errorf("%w: can not parse time(%s) in header(%s,%s) as format %s", ErrInvalidDate, dateStr, headKeyXAmzDate, headKeyData, iSO8601BasicFormat)
 		return
 	}
+	a.Date = t
 	if !strings.HasPrefix(dateStr, a.CredentialTime) {
-		err = fmt.Errorf("request time header(%s) do not match authorization's %s", dateStr, a.CredentialTime)
+		err = fmt.Errorf("%w: request time header(%s) do not match authorization's %s", ErrInvalidDate, dateStr, a.CredentialTime)
+		return
+	}
+	if err = a.checkScope(region, name); err != nil {
 		return
 	}
-	if a.Region != region || a.Name != name {
-		err = fmt.Errorf("invalid credential(region,name): %s", a.Credential)
+	if err = a.checkSignedHeaders(req, o); err != nil {
 		return
 	}
 
+	if a.byQuery {
+		err = a.checkExpires(t, o)
+	} else {
+		err = checkSkew(t, o)
+	}
 	return
 }
 
+func (a *Authorization) checkScope(region, name string) error {
+	if a.Algorithm != aws4EcdsaP256Sha256Algorithm {
+		if a.Region != region || a.Name != name {
+			return fmt.Errorf("%w: invalid credential(region,name): %s", ErrScopeMismatch, a.Credential)
+		}
+		return nil
+	}
+	if a.Name != name {
+		return fmt.Errorf("%w: invalid credential(name): %s", ErrScopeMismatch, a.Credential)
+	}
+	if !a.byQuery && !a.containsSignedHeader(headKeyRegionSet) {
+		return fmt.Errorf("%w: header %s must be signed", ErrMalformedAuthorization, headKeyRegionSet)
+	}
+	if !containsRegion(a.RegionSet, region) {
+		return fmt.Errorf("%w: region set %v does not include %s", ErrScopeMismatch, a.RegionSet, region)
+	}
+	return nil
+}
+
+/*
+checkSignedHeaders makes sure the required headers are signed.
+x-amz-date stands for the date header, it may be date when there is no x-amz-date,
+and is not needed for query string requests which have X-Amz-Date in the query.
+*/
+func (a *Authorization) checkSignedHeaders(req *http.Request, o *checkOptions) error {
+	for _, header := range o.requiredSignedHeaders {
+		header = strings.ToLower(header)
+		if header == headKeyXAmzDate {
+			if a.byQuery || a.containsSignedHeader(headKeyXAmzDate) ||
+				(req.Header.Get(headKeyXAmzDate) == "" && a.containsSignedHeader(headKeyData)) {
+				continue
+			}
+		} else if a.containsSignedHeader(header) {
+			continue
+		}
+		return fmt.Errorf("%w: header %s must be signed", ErrMalformedAuthorization, header)
+	}
+	return nil
+}
+
+// SkewError means the request time is too far from the server time, it is ErrRequestTimeSkewed
+type SkewError struct {
+	RequestTime time.Time
+	ServerTime  time.Time
+	MaxSkew     time.Duration
+}
+
+func (e *SkewError) Error() string {
+	return fmt.Sprintf("the difference between the request time(%s) and the server time(%s) is larger than %s",
+		e.RequestTime.UTC().Format(iSO8601BasicFormat), e.ServerTime.UTC().Format(iSO8601BasicFormat), e.MaxSkew)
+}
+
+// Unwrap makes errors.Is(err, ErrRequestTimeSkewed) true
+func (e *SkewError) Unwrap() error {
+	return ErrRequestTimeSkewed
+}
+
+func checkSkew(t time.Time, o *checkOptions) error {
+	now := o.now()
+	diff := now.Sub(t)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > o.maxSkew {
+		return &SkewError{RequestTime: t, ServerTime: now, MaxSkew: o.maxSkew}
+	}
+	return nil
+}
+
+/*
+checkExpires for query string
+https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+*/
+func (a *Authorization) checkExpires(t time.Time, o *checkOptions) error {
+	if a.Expires <= 0 {
+		return fmt.Errorf("%w: can not found %s in query", ErrMalformedAuthorization, queryKeyExpires)
+	}
+	if a.Expires > MaxPresignExpires {
+		return fmt.Errorf("%w: %s(%s) is longer than %s", ErrMalformedAuthorization, queryKeyExpires, a.Expires, MaxPresignExpires)
+	}
+	if a.Expires > o.maxExpires {
+		return fmt.Errorf("%w: %s(%s) is longer than allowed %s", ErrMalformedAuthorization, queryKeyExpires, a.Expires, o.maxExpires)
+	}
+	now := o.now()
+	// a url signed for later would otherwise stay valid far beyond its expires
+	if t.After(now.Add(o.maxSkew)) {
+		return &SkewError{RequestTime: t, ServerTime: now, MaxSkew: o.maxSkew}
+	}
+	if now.After(t.Add(a.Expires)) {
+		return fmt.Errorf("%w at %s, now: %s", ErrExpired, t.Add(a.Expires).Format(iSO8601BasicFormat), now.UTC().Format(iSO8601BasicFormat))
+	}
+	return nil
+}
+
 func (a *Authorization) containsSignedHeader(head string) bool {
 	if !a.initSignedHeadersMap {
 		a.signedHeadersMap = make(map[string]bool, len(a.SignedHeaders))
 		for _, item := range a.SignedHeaders {
 			a.signedHeadersMap[item] = true
 		}
+		a.initSignedHeadersMap = true
 	}
 	_, ok := a.signedHeadersMap[head]
 	return ok
 }
 
-func getValueString(prefix, content string) (result string, err error) {
-	if !strings.HasPrefix(content, prefix) {
-		err = fmt.Errorf("can not cut content: [%s] with prefix: [%s]", content, prefix)
-		return
-	}
-	result = strings.TrimSuffix(strings.TrimPrefix(content, prefix), ",")
-	return
-}
-
 // SignProcess record the sign process
 type SignProcess struct {
 	Key           []byte
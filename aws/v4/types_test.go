@@ -0,0 +1,78 @@
+package v4
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuthorization_Header(t *testing.T) {
+	const (
+		credential = "AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request"
+		signature  = "5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	)
+	valid := []string{
+		"AWS4-HMAC-SHA256 Credential=" + credential + ", SignedHeaders=host;x-amz-date, Signature=" + signature,
+		"AWS4-HMAC-SHA256 Credential=" + credential + ",SignedHeaders=host;x-amz-date,Signature=" + signature,
+		"AWS4-HMAC-SHA256  Credential=" + credential + " ,\tSignedHeaders=host;x-amz-date ,  Signature=" + signature + " ",
+		"AWS4-HMAC-SHA256 Signature=" + signature + ", Credential=" + credential + ", SignedHeaders=host;x-amz-date",
+	}
+	for _, content := range valid {
+		a, err := newAuthorizationByHeader(content)
+		if assert.NoError(t, err, content) {
+			assert.Equal(t, aws4HmacSha256Algorithm, a.Algorithm)
+			assert.Equal(t, credential, a.Credential)
+			assert.Equal(t, "AKIDEXAMPLE", a.AccessKeyID)
+			assert.Equal(t, []string{"host", "x-amz-date"}, a.SignedHeaders)
+			assert.Equal(t, signature, a.Signature)
+		}
+	}
+
+	invalid := []struct {
+		content string
+		offset  int
+		reason  string
+	}{
+		{"", 0, "missing algorithm"},
+		{"AWS4-HMAC-SHA256", 16, "missing component Credential"},
+		{"AWS4-HMAC-SHA256 Credential=" + credential, 28 + len(credential), "missing component SignedHeaders"},
+		{"AWS4-HMAC-SHA256 Credential " + credential, 27, `expected '=' after "Credential"`},
+		{"AWS4-HMAC-SHA256 Credential=, SignedHeaders=host, Signature=abc", 28, "empty Credential"},
+		{"AWS4-HMAC-SHA256 Credential=a b, SignedHeaders=host, Signature=abc", 30, "expected ',' after Credential"},
+		{"AWS4-HMAC-SHA256 Credentials=a, SignedHeaders=host, Signature=abc", 17, `unknown component "Credentials"`},
+		{"AWS4-HMAC-SHA256 Signature=a, SignedHeaders=host, Signature=abc", 50, "repeated component Signature"},
+	}
+	for _, tc := range invalid {
+		_, err := newAuthorizationByHeader(tc.content)
+		assert.ErrorIs(t, err, ErrMalformedAuthorization, tc.content)
+		var parseErr *AuthorizationParseError
+		if assert.True(t, errors.As(err, &parseErr), tc.content) {
+			assert.Equal(t, tc.offset, parseErr.Offset, tc.content)
+			assert.Equal(t, tc.reason, parseErr.Reason, tc.content)
+		}
+	}
+}
+
+func TestNewAuthorization_Ambiguous(t *testing.T) {
+	key := &Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	region, name := "us-east-1", "iam"
+
+	req := httptestRequest(t, "http://localhost:9527/app")
+	_, err := SignRequestWithAwsV4UseQueryString(req, key, region, name)
+	assert.NoError(t, err)
+	authorization := "AWS4-HMAC-SHA256 Credential=some_key_id/20150830/us-east-1/iam/aws4_request, SignedHeaders=host, Signature=abc"
+	req.Header.Set(headKeyAuthorization, authorization)
+	_, err = NewAuthorization(req)
+	assert.ErrorIs(t, err, ErrMalformedAuthorization)
+	assert.ErrorContains(t, err, "ambiguous")
+
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:9527/app", nil)
+	assert.NoError(t, err)
+	req.Header.Add(headKeyAuthorization, authorization)
+	req.Header.Add(headKeyAuthorization, authorization)
+	_, err = NewAuthorization(req)
+	assert.ErrorIs(t, err, ErrMalformedAuthorization)
+	assert.ErrorContains(t, err, "repeated")
+}
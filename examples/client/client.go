@@ -26,13 +26,11 @@ func main() {
 		panic(err)
 	}
 
-	// same as SignRequestWithAwsV4UseQueryString
-	_, err = awsv4.SignRequestWithAwsV4(req, key, region, name)
-	if err != nil {
-		panic(err)
+	// every request is signed by the transport, set UseQueryString to sign with query string
+	client := &http.Client{
+		Transport: awsv4.NewTransport(key, region, name),
 	}
-
-	response, err := http.DefaultClient.Do(req)
+	response, err := client.Do(req)
 	if err != nil {
 		panic(err)
 	}
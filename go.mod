@@ -1,11 +1,12 @@
 module github.com/LukeEuler/echo-awsv4
 
-go 1.20
+go 1.21
 
 require (
 	github.com/labstack/echo/v4 v4.11.2
 	github.com/stretchr/testify v1.8.4
 	golang.org/x/time v0.3.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -21,5 +22,4 @@ require (
 	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
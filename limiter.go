@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter decides whether an access key may spend cost of its budget on route.
+// Implementations must be safe for concurrent use.
+type Limiter interface {
+	Allow(ctx context.Context, accessKey, route string, cost int) (bool, error)
+}
+
+// TokenBucketLimiter gives every access key a token bucket, the budget is shared by all routes
+type TokenBucketLimiter struct {
+	every time.Duration
+	burst int
+	now   func() time.Time
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewTokenBucketLimiter adds a token every interval up to burst for every access key,
+// every <= 0 or burst <= 0 leaves keys without SetLimit unlimited
+func NewTokenBucketLimiter(every time.Duration, burst int) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		every:    every,
+		burst:    burst,
+		now:      time.Now,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// SetLimit gives accessKey a bucket of its own
+func (l *TokenBucketLimiter) SetLimit(accessKey string, every time.Duration, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limiters[accessKey] = rate.NewLimiter(rate.Every(every), burst)
+}
+
+// Allow implements Limiter
+func (l *TokenBucketLimiter) Allow(_ context.Context, accessKey, _ string, cost int) (bool, error) {
+	l.mu.Lock()
+	limiter, ok := l.limiters[accessKey]
+	if !ok {
+		if l.every <= 0 || l.burst <= 0 {
+			l.mu.Unlock()
+			return true, nil
+		}
+		limiter = rate.NewLimiter(rate.Every(l.every), l.burst)
+		l.limiters[accessKey] = limiter
+	}
+	l.mu.Unlock()
+	return limiter.AllowN(l.now(), cost), nil
+}
+
+// SlidingWindowLimiter allows limit cost per access key within any window, keeping a log of every request
+type SlidingWindowLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	logs map[string][]windowEntry
+}
+
+type windowEntry struct {
+	at   time.Time
+	cost int
+}
+
+// NewSlidingWindowLimiter allows limit cost per access key in the last window
+func NewSlidingWindowLimiter(limit int, window time.Duration) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		logs:   make(map[string][]windowEntry),
+	}
+}
+
+// Allow implements Limiter
+func (l *SlidingWindowLimiter) Allow(_ context.Context, accessKey, _ string, cost int) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	log := l.logs[accessKey]
+	for len(log) > 0 && !log[0].at.After(now.Add(-l.window)) {
+		log = log[1:]
+	}
+	used := 0
+	for _, entry := range log {
+		used += entry.cost
+	}
+	allowed := used+cost <= l.limit
+	if allowed {
+		log = append(log, windowEntry{at: now, cost: cost})
+	}
+	if len(log) == 0 {
+		delete(l.logs, accessKey)
+	} else {
+		l.logs[accessKey] = log
+	}
+	return allowed, nil
+}
+
+// FixedWindowLimiter allows limit cost per access key in every window, counted from the zero time
+type FixedWindowLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu       sync.Mutex
+	counters map[string]*windowCounter
+}
+
+type windowCounter struct {
+	start time.Time
+	used  int
+}
+
+// NewFixedWindowLimiter allows limit cost per access key in every window
+func NewFixedWindowLimiter(limit int, window time.Duration) *FixedWindowLimiter {
+	return &FixedWindowLimiter{
+		limit:    limit,
+		window:   window,
+		now:      time.Now,
+		counters: make(map[string]*windowCounter),
+	}
+}
+
+// Allow implements Limiter
+func (l *FixedWindowLimiter) Allow(_ context.Context, accessKey, _ string, cost int) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := l.now().Truncate(l.window)
+	counter, ok := l.counters[accessKey]
+	if !ok || !counter.start.Equal(start) {
+		counter = &windowCounter{start: start}
+		l.counters[accessKey] = counter
+	}
+	if counter.used+cost > l.limit {
+		return false, nil
+	}
+	counter.used += cost
+	return true, nil
+}
+
+// CounterStore keeps counters shared by several servers, e.g. INCRBY and EXPIREAT of redis
+type CounterStore interface {
+	// Increment adds delta to the counter of key and returns the new value,
+	// a new counter starts at 0 and is dropped at expireAt
+	Increment(ctx context.Context, key string, delta int64, expireAt time.Time) (int64, error)
+}
+
+// StoreLimiter is a fixed window limiter whose counters live in a CounterStore,
+// so all servers sharing the store share the budget of an access key
+type StoreLimiter struct {
+	store  CounterStore
+	limit  int
+	window time.Duration
+	now    func() time.Time
+	// Prefix is put before the counter keys
+	Prefix string
+}
+
+// NewStoreLimiter allows limit cost per access key in every window across all users of store
+func NewStoreLimiter(store CounterStore, limit int, window time.Duration) *StoreLimiter {
+	return &StoreLimiter{
+		store:  store,
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		Prefix: "awsv4:rate:",
+	}
+}
+
+// Allow implements Limiter. A denied cost is still counted, as the store can not take it back atomically.
+func (l *StoreLimiter) Allow(ctx context.Context, accessKey, _ string, cost int) (bool, error) {
+	start := l.now().Truncate(l.window)
+	key := l.Prefix + accessKey + ":" + strconv.FormatInt(start.Unix(), 10)
+	used, err := l.store.Increment(ctx, key, int64(cost), start.Add(l.window))
+	if err != nil {
+		return false, err
+	}
+	return used <= int64(l.limit), nil
+}
+
+// MemoryCounterStore is an in-process CounterStore, mostly a stand-in for tests
+type MemoryCounterStore struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	counters map[string]*storeCounter
+}
+
+type storeCounter struct {
+	value    int64
+	expireAt time.Time
+}
+
+// NewMemoryCounterStore returns an empty store
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{
+		now:      time.Now,
+		counters: make(map[string]*storeCounter),
+	}
+}
+
+// Increment implements CounterStore
+func (s *MemoryCounterStore) Increment(_ context.Context, key string, delta int64, expireAt time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for k, counter := range s.counters {
+		if !counter.expireAt.After(now) {
+			delete(s.counters, k)
+		}
+	}
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &storeCounter{expireAt: expireAt}
+		s.counters[key] = counter
+	}
+	counter.value += delta
+	return counter.value, nil
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func allow(t *testing.T, l Limiter, accessKey string, cost int) bool {
+	allowed, err := l.Allow(context.Background(), accessKey, "/", cost)
+	assert.NoError(t, err)
+	return allowed
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := NewTokenBucketLimiter(time.Second, 3)
+	l.now = clock.Now
+	l.SetLimit("small", time.Second, 1)
+
+	assert.True(t, allow(t, l, "a", 2))
+	assert.True(t, allow(t, l, "a", 1))
+	assert.False(t, allow(t, l, "a", 1))
+	assert.True(t, allow(t, l, "b", 3))
+	assert.False(t, allow(t, l, "small", 2))
+	assert.True(t, allow(t, l, "small", 1))
+
+	clock.now = clock.now.Add(2 * time.Second)
+	assert.True(t, allow(t, l, "a", 2))
+	assert.False(t, allow(t, l, "a", 1))
+
+	unlimited := NewTokenBucketLimiter(0, 0)
+	assert.True(t, allow(t, unlimited, "a", 1000))
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := NewSlidingWindowLimiter(3, time.Minute)
+	l.now = clock.Now
+
+	assert.True(t, allow(t, l, "a", 2))
+	clock.now = clock.now.Add(30 * time.Second)
+	assert.True(t, allow(t, l, "a", 1))
+	assert.False(t, allow(t, l, "a", 1))
+	assert.True(t, allow(t, l, "b", 3))
+
+	// the first request leaves the window, the second is still in it
+	clock.now = clock.now.Add(31 * time.Second)
+	assert.True(t, allow(t, l, "a", 2))
+	assert.False(t, allow(t, l, "a", 1))
+}
+
+func TestFixedWindowLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0).Truncate(time.Minute)}
+	l := NewFixedWindowLimiter(3, time.Minute)
+	l.now = clock.Now
+
+	assert.True(t, allow(t, l, "a", 3))
+	assert.False(t, allow(t, l, "a", 1))
+	clock.now = clock.now.Add(59 * time.Second)
+	assert.False(t, allow(t, l, "a", 1))
+	clock.now = clock.now.Add(time.Second)
+	assert.True(t, allow(t, l, "a", 3))
+}
+
+func TestStoreLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0).Truncate(time.Minute)}
+	store := NewMemoryCounterStore()
+	store.now = clock.Now
+	// two servers sharing one store
+	l1, l2 := NewStoreLimiter(store, 3, time.Minute), NewStoreLimiter(store, 3, time.Minute)
+	l1.now, l2.now = clock.Now, clock.Now
+
+	assert.True(t, allow(t, l1, "a", 2))
+	assert.True(t, allow(t, l2, "a", 1))
+	assert.False(t, allow(t, l1, "a", 1))
+	assert.True(t, allow(t, l2, "b", 1))
+
+	clock.now = clock.now.Add(time.Minute)
+	assert.True(t, allow(t, l2, "a", 3))
+	// the counters of the last window are dropped
+	store.mu.Lock()
+	assert.Len(t, store.counters, 1)
+	store.mu.Unlock()
+}
@@ -1,12 +1,16 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/labstack/echo/v4"
-	"golang.org/x/time/rate"
+	echomw "github.com/labstack/echo/v4/middleware"
 
 	awsv4 "github.com/LukeEuler/echo-awsv4/aws/v4"
 )
@@ -15,29 +19,109 @@ type AwsV4Config struct {
 	Region, Name     string
 	AwsCheckHandler  func(c echo.Context, err error)
 	RateCheckHandler func(c echo.Context, err error)
+	// Skipper lets a request through without any check, e.g. health checks
+	Skipper echomw.Skipper
+	// SkipPreflight lets CORS preflight requests (OPTIONS with Origin and Access-Control-Request-Method) through
+	SkipPreflight bool
+	// Routes overrides Region, Name and rate limits per route, keyed by the path the route is registered with,
+	// which is c.Path(), e.g. "/users/:id"
+	Routes map[string]RouteConfig
+	// MaxExpires caps X-Amz-Expires of query string requests, default awsv4.MaxPresignExpires
+	MaxExpires time.Duration
+	// MaxSkew is the clock skew allowed for header signed requests, default awsv4.DefaultMaxSkew
+	MaxSkew time.Duration
+	// AllowUnsignedPayload decides per request whether x-amz-content-sha256: UNSIGNED-PAYLOAD is accepted,
+	// nil rejects it everywhere but in presigned urls
+	AllowUnsignedPayload func(c echo.Context) bool
+	// SessionTokenValidator checks X-Amz-Security-Token once the signature matches
+	SessionTokenValidator awsv4.SessionTokenValidator
+	// ReplayStore rejects a request seen before with ErrReplayedRequest, nil disables replay protection
+	ReplayStore ReplayStore
+	// SigningKeyCache keeps derived signing keys, nil for the package wide cache,
+	// awsv4.NewSigningKeyCache(0) disables it
+	SigningKeyCache *awsv4.SigningKeyCache
+	// KeyStore looks up keys instead of the ones added by AddKey, their rate limits still apply
+	KeyStore awsv4.KeyStore
+	// Disclosure decides what a signature mismatch error tells the client, default awsv4.DisclosureNone
+	Disclosure awsv4.DisclosureMode
+	// Logger logs every auth decision with the fields of awsv4.AuthAttrs, nil logs nothing
+	Logger *slog.Logger
+	// S3PathEncoding expects paths signed without normalization and double encoding, as for Amazon S3
+	S3PathEncoding bool
+	// HeaderModes are the header canonicalizations accepted, tried in order, default awsv4.HeaderModeSpec only.
+	// {awsv4.HeaderModeSpec, awsv4.HeaderModeLegacy} lets clients migrate.
+	HeaderModes []awsv4.HeaderMode
+	// RequiredSignedHeaders must be signed by every request, default awsv4.DefaultRequiredSignedHeaders() (host and the date header)
+	RequiredSignedHeaders []string
+	// Limiter rate limits access keys, nil for the token buckets given by AddKey
+	Limiter Limiter
 
-	keys     map[string]string
-	limiters map[string]*rate.Limiter
+	keys     *awsv4.MemoryKeyStore
+	limiters *TokenBucketLimiter
 }
 
+// AddKey adds a key with a token bucket of times requests, refilled one every duration.
+// The bucket is ignored when Limiter is set.
 func (c *AwsV4Config) AddKey(accessKey, secretKey string, duration time.Duration, times int) error {
-	if len(c.keys) == 0 {
-		c.keys = make(map[string]string, 10)
-		c.limiters = make(map[string]*rate.Limiter, 10)
+	if c.keys == nil {
+		c.keys = awsv4.NewMemoryKeyStore()
+		c.limiters = NewTokenBucketLimiter(0, 0)
 	}
-	_, ok := c.keys[accessKey]
-	if ok {
-		return fmt.Errorf("repeated key: %s", accessKey)
+	err := c.keys.Add(&awsv4.KeyInfo{Key: awsv4.Key{AccessKey: accessKey, SecretKey: secretKey}})
+	if err != nil {
+		return err
 	}
-	c.keys[accessKey] = secretKey
-	c.limiters[accessKey] = rate.NewLimiter(rate.Every(duration), times)
+	c.limiters.SetLimit(accessKey, duration, times)
 	return nil
 }
 
+// ErrRateLimited is returned when the access key exceeds its rate limit
+var ErrRateLimited = errors.New("match rate limit")
+
+// DefaultAwsV4ContextHandler writes ErrorStatus of err. Only errors of the check itself are told to the client,
+// the text of a 5xx error, e.g. from a KeyStore, is replaced by the status text.
 func DefaultAwsV4ContextHandler(c echo.Context, err error) {
-	_ = c.String(http.StatusBadRequest, err.Error())
+	status := ErrorStatus(err)
+	switch {
+	case status >= http.StatusInternalServerError:
+		_ = c.String(status, http.StatusText(status))
+	case errors.Is(err, awsv4.ErrInvalidSecurityToken):
+		// the SessionTokenValidator error is wrapped in it
+		_ = c.String(status, awsv4.ErrInvalidSecurityToken.Error())
+	default:
+		_ = c.String(status, err.Error())
+	}
+}
+
+// ErrorStatus maps errors of the AwsV4 middleware to http status codes, 500 for unknown ones
+func ErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrReplayQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, awsv4.ErrUnknownAccessKey),
+		errors.Is(err, awsv4.ErrSignatureMismatch),
+		errors.Is(err, awsv4.ErrRequestTimeSkewed),
+		errors.Is(err, awsv4.ErrExpired),
+		errors.Is(err, awsv4.ErrInvalidSecurityToken),
+		errors.Is(err, ErrReplayedRequest):
+		return http.StatusForbidden
+	case errors.Is(err, ErrReplayStoreFull):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, awsv4.ErrMalformedAuthorization),
+		errors.Is(err, awsv4.ErrUnsupportedAlgorithm),
+		errors.Is(err, awsv4.ErrScopeMismatch),
+		errors.Is(err, awsv4.ErrInvalidDate),
+		errors.Is(err, awsv4.ErrInvalidPayload):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
+// AwsV4 checks every request with awsv4.CheckRequestWithAwsV4KeyStore.
+// A STREAMING-AWS4-HMAC-SHA256-PAYLOAD body reaches the handler already decoded,
+// reading it fails at the first chunk whose signature does not match.
+// The caller is then available to handlers through PrincipalFrom and PrincipalFromContext.
 func AwsV4(conf AwsV4Config) echo.MiddlewareFunc {
 	if conf.AwsCheckHandler == nil {
 		conf.AwsCheckHandler = DefaultAwsV4ContextHandler
@@ -45,22 +129,80 @@ func AwsV4(conf AwsV4Config) echo.MiddlewareFunc {
 	if conf.RateCheckHandler == nil {
 		conf.RateCheckHandler = DefaultAwsV4ContextHandler
 	}
+	store := conf.KeyStore
+	if store == nil {
+		if conf.keys == nil {
+			conf.keys = awsv4.NewMemoryKeyStore()
+		}
+		store = conf.keys
+	}
+	opts := []awsv4.CheckOption{
+		awsv4.WithMaxExpires(conf.MaxExpires),
+		awsv4.WithMaxSkew(conf.MaxSkew),
+		awsv4.WithSessionTokenValidator(conf.SessionTokenValidator),
+		awsv4.WithSigningKeyCache(conf.SigningKeyCache),
+		awsv4.WithDisclosure(conf.Disclosure),
+		awsv4.WithHeaderModes(conf.HeaderModes...),
+	}
+	if conf.RequiredSignedHeaders == nil {
+		conf.RequiredSignedHeaders = awsv4.DefaultRequiredSignedHeaders()
+	}
+	opts = append(opts, awsv4.WithRequiredSignedHeaders(conf.RequiredSignedHeaders...))
+	routeRequired := make(map[string]awsv4.CheckOption)
+	for route, routeConf := range conf.Routes {
+		if len(routeConf.RequiredSignedHeaders) > 0 {
+			headers := append(append([]string(nil), conf.RequiredSignedHeaders...), routeConf.RequiredSignedHeaders...)
+			routeRequired[route] = awsv4.WithRequiredSignedHeaders(headers...)
+		}
+	}
+	if conf.S3PathEncoding {
+		opts = append(opts, awsv4.WithS3PathEncodingCheck())
+	}
+	if conf.Limiter == nil && conf.limiters != nil {
+		conf.Limiter = conf.limiters
+	}
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			auth, _, err := awsv4.CheckRequestWithAwsV4KeyMaps(c.Request(), conf.keys, conf.Region, conf.Name)
+			if (conf.Skipper != nil && conf.Skipper(c)) || (conf.SkipPreflight && isPreflight(c)) {
+				return next(c)
+			}
+			start := time.Now()
+			checkOpts := opts
+			if conf.AllowUnsignedPayload != nil {
+				checkOpts = append(opts[:len(opts):len(opts)], awsv4.WithAllowUnsignedPayload(conf.AllowUnsignedPayload(c)))
+			}
+			region, name := conf.Region, conf.Name
+			routeConf := conf.Routes[c.Path()]
+			if required, ok := routeRequired[c.Path()]; ok {
+				checkOpts = append(checkOpts[:len(checkOpts):len(checkOpts)], required)
+			}
+			if routeConf.Region != "" {
+				region = routeConf.Region
+			}
+			if routeConf.Name != "" {
+				name = routeConf.Name
+			}
+			auth, sp, err := awsv4.CheckRequestWithAwsV4KeyStore(c.Request(), store, region, name, checkOpts...)
 			if err != nil {
+				logAuth(c, conf, auth, err, start)
 				conf.AwsCheckHandler(c, err)
 				return err
 			}
-			limiter := conf.limiters[auth.AccessKeyID]
-			allow := limiter.Allow()
-			if !allow {
-				ll := limiter.Limit()
-				fmt.Println(ll)
-				err := fmt.Errorf("match rate limit. key: %s", auth.AccessKeyID)
+			if err = checkRate(c, conf, routeConf, auth); err != nil {
+				logAuth(c, conf, auth, err, start)
 				conf.RateCheckHandler(c, err)
 				return err
 			}
+			// recorded last, a request turned down before may be retried as it is
+			if conf.ReplayStore != nil {
+				if err = checkReplay(c, conf, auth, sp); err != nil {
+					logAuth(c, conf, auth, err, start)
+					conf.AwsCheckHandler(c, err)
+					return err
+				}
+			}
+			logAuth(c, conf, auth, nil, start)
+			setPrincipal(c, auth)
 
 			if err = next(c); err != nil {
 				c.Error(err)
@@ -69,3 +211,55 @@ func AwsV4(conf AwsV4Config) echo.MiddlewareFunc {
 		}
 	}
 }
+
+func logAuth(c echo.Context, conf AwsV4Config, auth *awsv4.Authorization, err error, start time.Time) {
+	if conf.Logger == nil {
+		return
+	}
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		err = ErrRateLimited
+	case errors.Is(err, ErrReplayedRequest):
+		err = ErrReplayedRequest
+	}
+	awsv4.LogAuth(c.Request().Context(), conf.Logger, auth, err, time.Since(start), c.RealIP())
+}
+
+func checkRate(c echo.Context, conf AwsV4Config, routeConf RouteConfig, auth *awsv4.Authorization) error {
+	limiter := conf.Limiter
+	if routeConf.Limiter != nil {
+		limiter = routeConf.Limiter
+	}
+	if limiter == nil {
+		return nil
+	}
+	allowed, err := limiter.Allow(c.Request().Context(), auth.AccessKeyID, c.Path(), routeConf.cost())
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%w. key: %s", ErrRateLimited, auth.AccessKeyID)
+	}
+	return nil
+}
+
+// checkReplay keys the store on the string to sign, which the server computes,
+// as the signature text can be spelled another way, e.g. an ECDSA signature (r, n-s)
+func checkReplay(c echo.Context, conf AwsV4Config, auth *awsv4.Authorization, sp *awsv4.SignProcess) error {
+	expireAt := auth.Date.Add(conf.MaxSkew)
+	if conf.MaxSkew <= 0 {
+		expireAt = auth.Date.Add(awsv4.DefaultMaxSkew)
+	}
+	if auth.Expires > 0 {
+		expireAt = auth.Date.Add(auth.Expires)
+	}
+	digest := sha256.Sum256(sp.All)
+	added, err := conf.ReplayStore.Add(c.Request().Context(), auth.AccessKeyID+"/"+hex.EncodeToString(digest[:]), expireAt)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return ErrReplayedRequest
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	awsv4 "github.com/LukeEuler/echo-awsv4/aws/v4"
+)
+
+func BenchmarkAwsV4(b *testing.B) {
+	region, name := "universal", "echo_server"
+	key := &awsv4.Key{
+		AccessKey: "some_key_id",
+		SecretKey: `iQfiTM4xAPC3N@y26*vlVa^Yb&Vxa35Y`,
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost:12306/hi", strings.NewReader(`{"id": 1}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if _, err := awsv4.SignRequestWithAwsV4(req, key, region, name); err != nil {
+		b.Fatal(err)
+	}
+
+	run := func(b *testing.B, cache *awsv4.SigningKeyCache) {
+		conf := AwsV4Config{Region: region, Name: name, SigningKeyCache: cache}
+		if err := conf.AddKey(key.AccessKey, key.SecretKey, time.Nanosecond, b.N+1); err != nil {
+			b.Fatal(err)
+		}
+		e := echo.New()
+		h := AwsV4(conf)(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rec := httptest.NewRecorder()
+			if err := h(e.NewContext(req, rec)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("uncached", func(b *testing.B) { run(b, awsv4.NewSigningKeyCache(0)) })
+	b.Run("cached", func(b *testing.B) { run(b, nil) })
+}
+
+func TestAwsV4Logger(t *testing.T) {
+	region, name := "universal", "echo_server"
+	key := &awsv4.Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	var buf bytes.Buffer
+	conf := AwsV4Config{Region: region, Name: name, Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+	assert.NoError(t, conf.AddKey(key.AccessKey, key.SecretKey, time.Second, 10))
+	h := AwsV4(conf)(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e := echo.New()
+
+	var signatures []string
+	for _, secretKey := range []string{key.SecretKey, "other_secret"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:12306/hi", nil)
+		sp, err := awsv4.SignRequestWithAwsV4(req, &awsv4.Key{AccessKey: key.AccessKey, SecretKey: secretKey}, region, name)
+		assert.NoError(t, err)
+		signatures = append(signatures, hex.EncodeToString(sp.AllSHA256))
+		_ = h(e.NewContext(req, httptest.NewRecorder()))
+	}
+
+	output := buf.String()
+	for _, secret := range append(signatures, key.SecretKey) {
+		assert.NotContains(t, output, secret)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	assert.Len(t, lines, 2)
+	for i, outcome := range []string{"allow", "deny"} {
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(lines[i]), &record))
+		assert.Equal(t, key.AccessKey, record["access_key"])
+		assert.Contains(t, record["scope"], region+"/"+name+"/aws4_request")
+		assert.Equal(t, outcome, record["outcome"])
+		assert.Equal(t, "192.0.2.1", record["client_ip"])
+		assert.Contains(t, record, "latency")
+	}
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, awsv4.ErrSignatureMismatch.Error(), record["reason"])
+}
+
+func TestAwsV4Principal(t *testing.T) {
+	region, name := "universal", "echo_server"
+	key := awsv4.Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	conf := AwsV4Config{
+		Region:   region,
+		Name:     name,
+		KeyStore: awsv4.NewMemoryKeyStore(&awsv4.KeyInfo{Key: key, Metadata: map[string]string{"owner": "some_team"}}),
+	}
+	var fromEcho, fromContext *Principal
+	var owner string
+	h := AwsV4(conf)(func(c echo.Context) error {
+		fromEcho, _ = PrincipalFrom(c)
+		fromContext, _ = PrincipalFromContext(c.Request().Context())
+		owner = fromEcho.Metadata["owner"]
+		fromEcho.Metadata["owner"] = "changed_by_handler"
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:12306/hi", nil)
+	_, err := awsv4.SignRequestWithAwsV4(req, &key, region, name)
+	assert.NoError(t, err)
+	assert.NoError(t, h(echo.New().NewContext(req, httptest.NewRecorder())))
+
+	if assert.NotNil(t, fromEcho) {
+		assert.Equal(t, key.AccessKey, fromEcho.AccessKey)
+		assert.True(t, strings.HasSuffix(fromEcho.Scope, "/"+region+"/"+name+"/aws4_request"))
+		assert.Equal(t, []string{"host", "x-amz-date"}, fromEcho.SignedHeaders)
+		assert.Equal(t, "some_team", owner)
+	}
+	assert.Same(t, fromEcho, fromContext)
+
+	// the store keeps its own metadata
+	req = httptest.NewRequest(http.MethodGet, "http://localhost:12306/hi?n=2", nil)
+	_, err = awsv4.SignRequestWithAwsV4(req, &key, region, name)
+	assert.NoError(t, err)
+	assert.NoError(t, h(echo.New().NewContext(req, httptest.NewRecorder())))
+	info, err := conf.KeyStore.LookupKey(context.Background(), key.AccessKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "some_team", info.Metadata["owner"])
+
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	_, ok := PrincipalFrom(c)
+	assert.False(t, ok)
+}
+
+func TestAwsV4Group(t *testing.T) {
+	key := &awsv4.Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	conf := AwsV4Config{
+		Region:        "universal",
+		Name:          "echo_server",
+		Skipper:       func(c echo.Context) bool { return c.Path() == "/api/health" },
+		SkipPreflight: true,
+		Routes: map[string]RouteConfig{
+			"/api/other/:id": {Region: "cn-shenzhen", Name: "other_server"},
+			"/api/limited":   {Limiter: NewFixedWindowLimiter(1, time.Hour)},
+			"/api/expensive": {Weight: 60},
+			"/api/typed":     {RequiredSignedHeaders: []string{"content-type"}},
+		},
+	}
+	assert.NoError(t, conf.AddKey(key.AccessKey, key.SecretKey, time.Hour, 100))
+
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	g := e.Group("/api", AwsV4(conf))
+	g.GET("/health", ok)
+	g.GET("/items", ok)
+	g.OPTIONS("/items", ok)
+	g.GET("/other/:id", ok)
+	g.GET("/limited", ok)
+	g.GET("/expensive", ok)
+	g.GET("/typed", ok)
+
+	serve := func(req *http.Request) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	signed := func(target, region, name string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		_, err := awsv4.SignRequestWithAwsV4(req, key, region, name)
+		assert.NoError(t, err)
+		return req
+	}
+
+	assert.Equal(t, http.StatusOK, serve(httptest.NewRequest(http.MethodGet, "/api/health", nil)))
+	assert.Equal(t, http.StatusBadRequest, serve(httptest.NewRequest(http.MethodGet, "/api/items", nil)))
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/api/items", nil)
+	preflight.Header.Set(echo.HeaderOrigin, "http://example.com")
+	preflight.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodGet)
+	assert.Equal(t, http.StatusOK, serve(preflight))
+	// not a preflight without Access-Control-Request-Method
+	preflight.Header.Del(echo.HeaderAccessControlRequestMethod)
+	assert.Equal(t, http.StatusBadRequest, serve(preflight))
+
+	assert.Equal(t, http.StatusOK, serve(signed("/api/items", "universal", "echo_server")))
+	assert.Equal(t, http.StatusOK, serve(signed("/api/other/1", "cn-shenzhen", "other_server")))
+	assert.Equal(t, http.StatusBadRequest, serve(signed("/api/other/1", "universal", "echo_server")))
+	assert.Equal(t, http.StatusBadRequest, serve(signed("/api/typed", "universal", "echo_server")))
+	typed := httptest.NewRequest(http.MethodGet, "/api/typed", nil)
+	typed.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	_, err := awsv4.SignRequestWithAwsV4(typed, key, "universal", "echo_server")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serve(typed))
+
+	assert.Equal(t, http.StatusOK, serve(signed("/api/limited", "universal", "echo_server")))
+	assert.Equal(t, http.StatusTooManyRequests, serve(signed("/api/limited", "universal", "echo_server")))
+	// the key budget of other routes is untouched
+	assert.Equal(t, http.StatusOK, serve(signed("/api/items", "universal", "echo_server")))
+
+	// 4 requests to the other routes and one weighing 60 leave 35 of 100
+	assert.Equal(t, http.StatusOK, serve(signed("/api/expensive", "universal", "echo_server")))
+	assert.Equal(t, http.StatusTooManyRequests, serve(signed("/api/expensive", "universal", "echo_server")))
+	assert.Equal(t, http.StatusOK, serve(signed("/api/items", "universal", "echo_server")))
+}
+
+func TestAwsV4Replay(t *testing.T) {
+	region, name := "universal", "echo_server"
+	key := awsv4.Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	conf := AwsV4Config{
+		Region:      region,
+		Name:        name,
+		KeyStore:    awsv4.NewMemoryKeyStore(&awsv4.KeyInfo{Key: key}),
+		ReplayStore: NewMemoryReplayStore(0),
+	}
+	h := AwsV4(conf)(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	serve := func(req *http.Request) (int, error) {
+		rec := httptest.NewRecorder()
+		err := h(echo.New().NewContext(req, rec))
+		return rec.Code, err
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:12306/hi", nil)
+	sp, err := awsv4.SignRequestWithAwsV4(req, &key, region, name, awsv4.WithSigV4a())
+	assert.NoError(t, err)
+	code, err := serve(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, err = serve(req)
+	assert.ErrorIs(t, err, ErrReplayedRequest)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// (r, n-s) is a valid signature of the same request
+	var signature struct{ R, S *big.Int }
+	_, err = asn1.Unmarshal(sp.AllSHA256, &signature)
+	assert.NoError(t, err)
+	signature.S.Sub(elliptic.P256().Params().N, signature.S)
+	malleated, err := asn1.Marshal(signature)
+	assert.NoError(t, err)
+	authorization := req.Header.Get(echo.HeaderAuthorization)
+	req.Header.Set(echo.HeaderAuthorization, strings.Replace(authorization, hex.EncodeToString(sp.AllSHA256), hex.EncodeToString(malleated), 1))
+	code, err = serve(req)
+	assert.ErrorIs(t, err, ErrReplayedRequest)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+type brokenKeyStore struct{ err error }
+
+func (s brokenKeyStore) LookupKey(context.Context, string) (*awsv4.KeyInfo, error) {
+	return nil, s.err
+}
+
+func TestAwsV4ErrorBody(t *testing.T) {
+	region, name := "universal", "echo_server"
+	key := awsv4.Key{AccessKey: "some_key_id", SecretKey: "some_secret", SessionToken: "some_token"}
+	serve := func(conf AwsV4Config) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:12306/hi", nil)
+		_, err := awsv4.SignRequestWithAwsV4(req, &key, region, name)
+		assert.NoError(t, err)
+		rec := httptest.NewRecorder()
+		h := AwsV4(conf)(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+		_ = h(echo.New().NewContext(req, rec))
+		return rec
+	}
+
+	rec := serve(AwsV4Config{
+		Region:   region,
+		Name:     name,
+		KeyStore: brokenKeyStore{err: errors.New("dial tcp 10.0.0.7:5432: connection refused")},
+	})
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError), rec.Body.String())
+
+	rec = serve(AwsV4Config{
+		Region:   region,
+		Name:     name,
+		KeyStore: awsv4.NewMemoryKeyStore(&awsv4.KeyInfo{Key: key}),
+		SessionTokenValidator: func(context.Context, string, string) error {
+			return errors.New("token table sts_tokens is locked")
+		},
+	})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, awsv4.ErrInvalidSecurityToken.Error(), rec.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, ErrorStatus(fmt.Errorf("%w: bad", awsv4.ErrMalformedAuthorization)))
+	assert.Equal(t, http.StatusServiceUnavailable, ErrorStatus(ErrReplayStoreFull))
+	assert.Equal(t, http.StatusTooManyRequests, ErrorStatus(ErrReplayQuotaExceeded))
+	assert.Equal(t, http.StatusInternalServerError, ErrorStatus(errors.New("unknown")))
+}
+
+func TestAwsV4RateBeforeReplay(t *testing.T) {
+	region, name := "universal", "echo_server"
+	key := awsv4.Key{AccessKey: "some_key_id", SecretKey: "some_secret"}
+	clock := &fakeClock{now: time.Now()}
+	limiter := NewTokenBucketLimiter(time.Minute, 1)
+	limiter.now = clock.Now
+	conf := AwsV4Config{
+		Region:      region,
+		Name:        name,
+		KeyStore:    awsv4.NewMemoryKeyStore(&awsv4.KeyInfo{Key: key}),
+		ReplayStore: NewMemoryReplayStore(0),
+		Limiter:     limiter,
+	}
+	h := AwsV4(conf)(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	serve := func(req *http.Request) int {
+		rec := httptest.NewRecorder()
+		_ = h(echo.New().NewContext(req, rec))
+		return rec.Code
+	}
+	signed := func(target string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		_, err := awsv4.SignRequestWithAwsV4(req, &key, region, name)
+		assert.NoError(t, err)
+		return req
+	}
+
+	assert.Equal(t, http.StatusOK, serve(signed("http://localhost:12306/hi?n=1")))
+	// a throttled request is not recorded, its retry passes once the bucket refills
+	throttled := signed("http://localhost:12306/hi?n=2")
+	assert.Equal(t, http.StatusTooManyRequests, serve(throttled))
+	clock.now = clock.now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, serve(throttled))
+	clock.now = clock.now.Add(time.Minute)
+	assert.Equal(t, http.StatusForbidden, serve(throttled))
+}
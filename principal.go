@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"maps"
+
+	"github.com/labstack/echo/v4"
+
+	awsv4 "github.com/LukeEuler/echo-awsv4/aws/v4"
+)
+
+// PrincipalKey is the echo.Context key of the Principal set by AwsV4
+const PrincipalKey = "awsv4.principal"
+
+type principalContextKey struct{}
+
+// Principal is the caller authenticated by AwsV4
+type Principal struct {
+	AccessKey string
+	// Scope is the credential without the access key, e.g. 20150830/us-east-1/iam/aws4_request
+	Scope         string
+	SignedHeaders []string
+	// Metadata is a copy of awsv4.KeyInfo.Metadata of the key from the store
+	Metadata map[string]string
+}
+
+func newPrincipal(auth *awsv4.Authorization) *Principal {
+	return &Principal{
+		AccessKey:     auth.AccessKeyID,
+		Scope:         auth.Scope(),
+		SignedHeaders: auth.SignedHeaders,
+		Metadata:      maps.Clone(auth.Metadata),
+	}
+}
+
+// PrincipalFrom returns the Principal of a request passed by AwsV4
+func PrincipalFrom(c echo.Context) (*Principal, bool) {
+	p, ok := c.Get(PrincipalKey).(*Principal)
+	return p, ok
+}
+
+// PrincipalFromContext returns the Principal from the context of a request passed by AwsV4
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return p, ok
+}
+
+// ContextWithPrincipal returns a copy of ctx carrying p, as AwsV4 does for the request context
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+func setPrincipal(c echo.Context, auth *awsv4.Authorization) {
+	p := newPrincipal(auth)
+	c.Set(PrincipalKey, p)
+	req := c.Request()
+	c.SetRequest(req.WithContext(ContextWithPrincipal(req.Context(), p)))
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrReplayedRequest is returned when a request has already been accepted
+var ErrReplayedRequest = errors.New("awsv4 request has already been used")
+
+// ErrReplayStoreFull is returned when a MemoryReplayStore holds capacity requests that have not expired
+var ErrReplayStoreFull = errors.New("awsv4 replay store is full")
+
+// ErrReplayQuotaExceeded is returned when an access key holds KeyCapacity requests of a MemoryReplayStore
+var ErrReplayQuotaExceeded = errors.New("awsv4 access key has too many requests in the replay store")
+
+// DefaultReplayCapacity is the size of NewMemoryReplayStore when capacity <= 0
+const DefaultReplayCapacity = 100000
+
+// ReplayStore remembers accepted requests until they expire.
+// The key is the access key, a slash and the sha256 of the string to sign, not the signature sent by the client.
+// Implementations must be safe for concurrent use, one store may be shared by several middlewares.
+type ReplayStore interface {
+	// Add records key until expireAt, added is false if it is already recorded and not expired
+	Add(ctx context.Context, key string, expireAt time.Time) (added bool, err error)
+}
+
+// MemoryReplayStore is an in-process ReplayStore with bounded size.
+// When full, new requests are rejected with ErrReplayStoreFull until entries expire,
+// dropping an entry early would let its request be replayed.
+// An access key holding KeyCapacity entries gets ErrReplayQuotaExceeded instead,
+// so one key can not fill the store for all the others.
+type MemoryReplayStore struct {
+	capacity int
+	now      func() time.Time
+	// KeyCapacity limits the entries of one access key, the text of a key before the first slash, <= 0 for no limit
+	KeyCapacity int
+
+	mu      sync.Mutex
+	entries map[string]*replayEntry
+	counts  map[string]int
+	queue   replayQueue
+}
+
+// NewMemoryReplayStore holds at most capacity requests, a tenth of them for one access key
+func NewMemoryReplayStore(capacity int) *MemoryReplayStore {
+	if capacity <= 0 {
+		capacity = DefaultReplayCapacity
+	}
+	return &MemoryReplayStore{
+		capacity:    capacity,
+		now:         time.Now,
+		KeyCapacity: max(capacity/10, 1),
+		entries:     make(map[string]*replayEntry),
+		counts:      make(map[string]int),
+	}
+}
+
+// Add implements ReplayStore
+func (s *MemoryReplayStore) Add(_ context.Context, key string, expireAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for len(s.queue) > 0 && !s.queue[0].expireAt.After(now) {
+		e := heap.Pop(&s.queue).(*replayEntry)
+		delete(s.entries, e.key)
+		if s.counts[e.owner]--; s.counts[e.owner] <= 0 {
+			delete(s.counts, e.owner)
+		}
+	}
+	if _, ok := s.entries[key]; ok {
+		return false, nil
+	}
+	if !expireAt.After(now) {
+		return true, nil
+	}
+	owner, _, _ := strings.Cut(key, "/")
+	if s.KeyCapacity > 0 && s.counts[owner] >= s.KeyCapacity {
+		return false, ErrReplayQuotaExceeded
+	}
+	if len(s.queue) >= s.capacity {
+		return false, ErrReplayStoreFull
+	}
+	e := &replayEntry{key: key, owner: owner, expireAt: expireAt}
+	heap.Push(&s.queue, e)
+	s.entries[key] = e
+	s.counts[owner]++
+	return true, nil
+}
+
+// Len returns the number of remembered requests
+func (s *MemoryReplayStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+type replayEntry struct {
+	key      string
+	owner    string
+	expireAt time.Time
+}
+
+// replayQueue is a min-heap ordered by expireAt
+type replayQueue []*replayEntry
+
+func (q replayQueue) Len() int           { return len(q) }
+func (q replayQueue) Less(i, j int) bool { return q[i].expireAt.Before(q[j].expireAt) }
+func (q replayQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *replayQueue) Push(x any) {
+	*q = append(*q, x.(*replayEntry))
+}
+
+func (q *replayQueue) Pop() any {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryReplayStore(t *testing.T) {
+	now := time.Date(2023, 10, 23, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryReplayStore(2)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	added, err := s.Add(ctx, "a", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	added, _ = s.Add(ctx, "a", now.Add(time.Minute))
+	assert.False(t, added)
+
+	_, _ = s.Add(ctx, "b", now.Add(2*time.Minute))
+	added, err = s.Add(ctx, "c", now.Add(3*time.Minute))
+	assert.ErrorIs(t, err, ErrReplayStoreFull)
+	assert.False(t, added)
+	assert.Equal(t, 2, s.Len())
+
+	// "a" is still remembered when the store is full
+	added, err = s.Add(ctx, "a", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	// expired entries make room
+	now = now.Add(90 * time.Second)
+	added, err = s.Add(ctx, "c", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, 2, s.Len())
+
+	now = now.Add(time.Hour)
+	added, _ = s.Add(ctx, "c", now.Add(time.Minute))
+	assert.True(t, added)
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestMemoryReplayStore_KeyCapacity(t *testing.T) {
+	now := time.Date(2023, 10, 23, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryReplayStore(20)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+	assert.Equal(t, 2, s.KeyCapacity)
+
+	_, _ = s.Add(ctx, "greedy/1", now.Add(7*24*time.Hour))
+	_, _ = s.Add(ctx, "greedy/2", now.Add(time.Minute))
+	added, err := s.Add(ctx, "greedy/3", now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrReplayQuotaExceeded)
+	assert.False(t, added)
+
+	// other access keys are not affected
+	added, err = s.Add(ctx, "other/1", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	// an expired entry gives its slot back
+	now = now.Add(2 * time.Minute)
+	added, err = s.Add(ctx, "greedy/3", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, 2, s.Len())
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RouteConfig overrides AwsV4Config for one route, zero fields keep the AwsV4Config values
+type RouteConfig struct {
+	Region, Name string
+	// Limiter replaces AwsV4Config.Limiter on this route, so the route has a budget of its own
+	Limiter Limiter
+	// Weight is the cost of one request to the route, 1 if <= 0
+	Weight int
+	// RequiredSignedHeaders are required on this route besides AwsV4Config.RequiredSignedHeaders,
+	// e.g. content-type or x-amz-content-sha256
+	RequiredSignedHeaders []string
+}
+
+func (r RouteConfig) cost() int {
+	if r.Weight <= 0 {
+		return 1
+	}
+	return r.Weight
+}
+
+// isPreflight reports a CORS preflight request, which never carries credentials
+func isPreflight(c echo.Context) bool {
+	req := c.Request()
+	return req.Method == http.MethodOptions &&
+		req.Header.Get(echo.HeaderOrigin) != "" &&
+		req.Header.Get(echo.HeaderAccessControlRequestMethod) != ""
+}